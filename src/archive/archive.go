@@ -0,0 +1,170 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"protocoles-internet-2023/filestructure"
+	udptypes "protocoles-internet-2023/udp"
+	"strings"
+	"time"
+)
+
+const (
+	Tar = "tar"
+	Zip = "zip"
+)
+
+/*
+Writes the remote tree below node as a tar or zip stream
+The tree is walked lazily and every file is copied chunk after chunk, so
+nothing but the node being written is kept in memory; a tar goes through a
+temporary file for each file of several chunks
+name is the path of node inside the archive
+*/
+func WriteArchive(w io.Writer, format string, sched *udptypes.Scheduler, node udptypes.RemoteNode, name string, dest *net.UDPAddr) error {
+	switch format {
+	case Tar:
+		return writeTar(w, sched, node, name, dest)
+	case Zip:
+		return writeZip(w, sched, node, name, dest)
+	default:
+		return errors.New("unknown archive format: " + format)
+	}
+}
+
+/*
+Names come from the peer, a name able to escape the archive root when
+extracted is refused
+*/
+func checkPath(path string) error {
+	for _, name := range strings.Split(path, "/") {
+		if err := filestructure.CheckName(name); err != nil {
+			return errors.New("in remote tree: " + err.Error())
+		}
+	}
+	return nil
+}
+
+/*
+A tar header needs the size of the file before its content: a file of
+several chunks is first copied to a temporary file, so that each chunk is
+fetched once, then written from there
+*/
+func writeTar(w io.Writer, sched *udptypes.Scheduler, node udptypes.RemoteNode, name string, dest *net.UDPAddr) error {
+	tw := tar.NewWriter(w)
+	modTime := time.Now()
+
+	err := sched.WalkTree(node, name, dest, func(path string, node udptypes.RemoteNode) error {
+		if err := checkPath(path); err != nil {
+			return err
+		}
+
+		if node.Type == udptypes.DirectoryNode {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     path + "/",
+				Mode:     0755,
+				ModTime:  modTime,
+			})
+		}
+
+		if node.Type == udptypes.ChunkNode {
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path,
+				Size:     int64(len(node.Data)),
+				Mode:     0644,
+				ModTime:  modTime,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(node.Data)
+			return err
+		}
+
+		return spool(sched, node, dest, func(content io.Reader, size int64) error {
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path,
+				Size:     size,
+				Mode:     0644,
+				ModTime:  modTime,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, content)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+/*
+Copies the remote file to a temporary file and calls write with its content
+and size, the temporary file is removed afterwards
+*/
+func spool(sched *udptypes.Scheduler, node udptypes.RemoteNode, dest *net.UDPAddr, write func(io.Reader, int64) error) error {
+	file, err := os.CreateTemp("", "p2p-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := sched.CopyFile(file, node, dest, 0, -1)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return write(file, size)
+}
+
+func writeZip(w io.Writer, sched *udptypes.Scheduler, node udptypes.RemoteNode, name string, dest *net.UDPAddr) error {
+	zw := zip.NewWriter(w)
+	modTime := time.Now()
+
+	err := sched.WalkTree(node, name, dest, func(path string, node udptypes.RemoteNode) error {
+		if err := checkPath(path); err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:     path,
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		if node.Type == udptypes.DirectoryNode {
+			header.Name += "/"
+			header.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if node.Type == udptypes.DirectoryNode {
+			return nil
+		}
+
+		_, err = sched.CopyFile(fw, node, dest, 0, -1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
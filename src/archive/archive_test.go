@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"protocoles-internet-2023/filestructure"
+	udptypes "protocoles-internet-2023/udp"
+	"strconv"
+	"testing"
+)
+
+/*
+Remote tree already in the cache of the scheduler, so that it is archived
+without asking any peer:
+
+	docs/
+	docs/big.bin    several chunks
+	hello.txt
+*/
+type remoteTree struct {
+	sched *udptypes.Scheduler
+	root  udptypes.RemoteNode
+	files map[string][]byte
+}
+
+func (tree *remoteTree) add(node udptypes.RemoteNode, content []byte) udptypes.RemoteNode {
+	node.Hash = sha256.Sum256(append([]byte{node.Type, byte(len(node.Children))}, content...))
+	tree.sched.Cache.Nodes[node.Hash] = node
+	return node
+}
+
+func (tree *remoteTree) file(content []byte) udptypes.RemoteNode {
+	if len(content) <= filestructure.ChunkSize {
+		return tree.add(udptypes.RemoteNode{Type: udptypes.ChunkNode, Data: content}, content)
+	}
+
+	bigfile := udptypes.RemoteNode{Type: udptypes.BigfileNode}
+	for offset := 0; offset < len(content); offset += filestructure.ChunkSize {
+		data := content[offset:min(offset+filestructure.ChunkSize, len(content))]
+		chunk := tree.file(data)
+		bigfile.Children = append(bigfile.Children, filestructure.Child{Hash: chunk.Hash, Name: strconv.Itoa(offset)})
+	}
+	return tree.add(bigfile, content)
+}
+
+// children alternate names and nodes
+func (tree *remoteTree) directory(name string, children ...any) udptypes.RemoteNode {
+	directory := udptypes.RemoteNode{Name: name, Type: udptypes.DirectoryNode}
+	for i := 0; i < len(children); i += 2 {
+		child := children[i+1].(udptypes.RemoteNode)
+		directory.Children = append(directory.Children, filestructure.Child{Hash: child.Hash, Name: children[i].(string)})
+	}
+	return tree.add(directory, []byte(name+children[0].(string)))
+}
+
+func newRemoteTree() *remoteTree {
+	tree := &remoteTree{sched: udptypes.NewScheduler(udptypes.UDPSock{}, nil, nil, nil)}
+
+	big := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(big)
+	tree.files = map[string][]byte{
+		"export/docs/big.bin": big,
+		"export/hello.txt":    []byte("hello\n"),
+	}
+
+	docs := tree.directory("docs", "big.bin", tree.file(big))
+	tree.root = tree.directory("export", "docs", docs, "hello.txt", tree.file([]byte("hello\n")))
+	return tree
+}
+
+func readTar(t *testing.T, archive []byte) (files map[string][]byte, dirs []string) {
+	t.Helper()
+	files = make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, dirs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header.Name)
+			continue
+		}
+		if files[header.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readZip(t *testing.T, archive []byte) (files map[string][]byte, dirs []string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = make(map[string][]byte)
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			dirs = append(dirs, file.Name)
+			continue
+		}
+		content, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[file.Name], err = io.ReadAll(content); err != nil {
+			t.Fatal(err)
+		}
+		content.Close()
+	}
+	return files, dirs
+}
+
+func TestWriteArchive(t *testing.T) {
+	tree := newRemoteTree()
+
+	formats := map[string]func(*testing.T, []byte) (map[string][]byte, []string){
+		Tar: readTar,
+		Zip: readZip,
+	}
+	for format, read := range formats {
+		t.Run(format, func(t *testing.T) {
+			var archive bytes.Buffer
+			if err := WriteArchive(&archive, format, tree.sched, tree.root, "export", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			files, dirs := read(t, archive.Bytes())
+			if len(files) != len(tree.files) {
+				t.Fatalf("%d files in the archive, want %d", len(files), len(tree.files))
+			}
+			for name, content := range tree.files {
+				if !bytes.Equal(files[name], content) {
+					t.Errorf("%s differs", name)
+				}
+			}
+			if len(dirs) != 2 || dirs[0] != "export/" || dirs[1] != "export/docs/" {
+				t.Errorf("directories %v, want export/ and export/docs/", dirs)
+			}
+		})
+	}
+
+	var archive bytes.Buffer
+	if err := WriteArchive(&archive, "rar", tree.sched, tree.root, "export", nil); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestWriteArchiveEscape(t *testing.T) {
+	tree := newRemoteTree()
+	root := tree.directory("export", "../escape", tree.file([]byte("outside\n")))
+
+	for _, format := range []string{Tar, Zip} {
+		if err := WriteArchive(io.Discard, format, tree.sched, root, "export", nil); err == nil {
+			t.Errorf("%s: name escaping the archive accepted", format)
+		}
+	}
+}
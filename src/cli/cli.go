@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/logging"
+	"protocoles-internet-2023/node"
+)
+
+// exit codes
+const (
+	ExitOK         = 0
+	ExitError      = 1 // network or local failure
+	ExitUsage      = 2 // bad command line, same as the flag package
+	ExitNotFound   = 3 // unknown peer, path or datum
+	ExitKeyChanged = 4 // the peer sent another key than the pinned one
+)
+
+type command struct {
+	usage string
+	run   func(s *session, args []string) error
+}
+
+var commands = map[string]command{
+	"peers":       {"peers", peersCommand},
+	"server":      {"server", serverCommand},
+	"connect":     {"connect <peer>", connectCommand},
+	"hello":       {"hello <peer>", helloCommand},
+	"root":        {"root <peer>", rootCommand},
+	"ls":          {"ls <peer> [path]", lsCommand},
+	"get":         {"get <peer> <path> <dest>", getCommand},
+	"cat":         {"cat <peer> <path>", catCommand},
+	"archive":     {"archive [-format tar|zip] [-o file] <peer> [path]", archiveCommand},
+	"export":      {"export <dir>", exportCommand},
+	"known-peers": {"known-peers", knownPeersCommand},
+	"accept-key":  {"accept-key <peer>", acceptKeyCommand},
+	"keys":        {"keys generate [-force] | import [-force] <file.pem> | export [-private] [-o file] | fingerprint | rotate", keysCommand},
+}
+
+// order in which commands are listed in the usage
+var commandNames = []string{"peers", "server", "connect", "hello", "root", "ls", "get", "cat", "archive", "export", "known-peers", "accept-key", "keys"}
+
+/*
+What the commands share, the commands go through the control API of the
+node running with the same configuration, or of a node started for the
+command only if there is none
+*/
+type session struct {
+	config config.Config
+	ctl    *control.Client
+	node   *node.Node
+	server *control.Server
+	tmpDir string
+}
+
+var errUsage = errors.New("usage")
+
+func (s *session) client() (*control.Client, error) {
+	if s.ctl != nil {
+		return s.ctl, nil
+	}
+
+	if client, err := control.Dial(s.config.ControlSocketPath()); err == nil {
+		s.ctl = client
+		return client, nil
+	}
+
+	// nothing is exported and any port will do
+	cfg := s.config
+	cfg.Exports = nil
+	cfg.ListenAddress = ""
+
+	localNode, err := node.NewNode(cfg)
+	if err != nil {
+		return nil, err
+	}
+	go localNode.Scheduler.Launch(localNode.Socket)
+	s.node = localNode
+
+	s.tmpDir, err = os.MkdirTemp("", "p2p-cli")
+	if err != nil {
+		return nil, err
+	}
+
+	socketPath := filepath.Join(s.tmpDir, "control.sock")
+	s.server = control.NewServer(localNode, cfg.DownloadDir)
+	if err = s.server.Listen(socketPath); err != nil {
+		return nil, err
+	}
+
+	s.ctl, err = control.Dial(socketPath)
+	return s.ctl, err
+}
+
+func (s *session) close() {
+	if s.server != nil {
+		s.server.Shutdown(context.Background())
+	}
+	if s.node != nil {
+		s.node.Shutdown()
+	}
+	if s.tmpDir != "" {
+		os.RemoveAll(s.tmpDir)
+	}
+}
+
+/*
+Recognizes the name of a subcommand
+*/
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+func Usage() {
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range commandNames {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+/*
+Runs the subcommand args[0] with its arguments and returns the exit code
+Results are printed on stdout and errors on stderr, debug and info logs are
+disabled so that the output can be used by scripts
+*/
+func Run(args []string, cfg config.Config) int {
+
+	if len(args) == 0 {
+		Usage()
+		return ExitUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command: "+args[0])
+		Usage()
+		return ExitUsage
+	}
+
+	// only warnings and errors, on stderr
+	logging.SetLevel(slog.LevelWarn)
+
+	s := &session{
+		config: cfg,
+	}
+	defer s.close()
+
+	err := cmd.run(s, args[1:])
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp):
+		fmt.Fprintln(os.Stderr, "usage: "+cmd.usage)
+		return ExitUsage
+	case errors.Is(err, control.ErrKeyChanged):
+		fmt.Fprintln(os.Stderr, args[0]+": "+err.Error())
+		fmt.Fprintln(os.Stderr, "WARNING: the public key of the peer changed, someone may be impersonating it.")
+		fmt.Fprintln(os.Stderr, "Run \"accept-key <peer>\" only if you know why the key changed.")
+		return ExitKeyChanged
+	case errors.Is(err, control.ErrNotFound):
+		fmt.Fprintln(os.Stderr, args[0]+": "+err.Error())
+		return ExitNotFound
+	default:
+		fmt.Fprintln(os.Stderr, args[0]+": "+err.Error())
+		return ExitError
+	}
+}
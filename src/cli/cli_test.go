@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/directory"
+	"protocoles-internet-2023/node"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/*
+Directory server with alice and bob registered, bob exporting a small tree
+Returns the configuration of alice, whose node serves the commands on its
+control socket
+*/
+func newTestNetwork(t *testing.T) config.Config {
+	t.Helper()
+
+	srv, err := directory.NewServer("jch.irif.fr", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(func() { srv.Close() })
+	rest := httptest.NewServer(srv)
+	t.Cleanup(rest.Close)
+
+	exports := t.TempDir()
+	if err = os.Mkdir(filepath.Join(exports, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"hello.txt": "hello\n", "docs/notes.txt": "notes\n"} {
+		if err = os.WriteFile(filepath.Join(exports, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bob := testConfig(t, "bob", rest.URL)
+	bob.Exports = []string{exports}
+	startNode(t, bob)
+
+	alice := testConfig(t, "alice", rest.URL)
+	server := control.NewServer(startNode(t, alice), alice.DownloadDir)
+	if err = server.Listen(alice.ControlSocketPath()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return alice
+}
+
+func startNode(t *testing.T, cfg config.Config) *node.Node {
+	t.Helper()
+	started, err := node.NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started.Start()
+	t.Cleanup(started.Shutdown)
+	return started
+}
+
+func testConfig(t *testing.T, name string, endpoint string) config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Endpoint = endpoint
+	cfg.PeerName = name
+	cfg.ListenAddress = "127.0.0.1:0"
+	cfg.KeyStore = filepath.Join(t.TempDir(), "keys.db")
+	cfg.Exports = []string{t.TempDir()}
+	cfg.KnownPeers = ""
+	cfg.ControlSocket = filepath.Join(t.TempDir(), "control.sock")
+	cfg.DownloadDir = t.TempDir()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// exit code and standard output of the command
+func run(t *testing.T, cfg config.Config, args ...string) (int, string) {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		output <- data
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	code := Run(args, cfg)
+	os.Stdout = stdout
+	writer.Close()
+
+	return code, string(<-output)
+}
+
+func TestUsage(t *testing.T) {
+	cfg := config.Default()
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"ls"},
+		{"cat", "bob"},
+		{"get", "bob", "hello.txt"},
+		{"archive", "-level", "9", "bob"},
+	} {
+		if code, _ := run(t, cfg, args...); code != ExitUsage {
+			t.Errorf("%v: exit code %d, want %d", args, code, ExitUsage)
+		}
+	}
+	if code, _ := run(t, cfg, "archive", "-format", "rar", "bob"); code != ExitError {
+		t.Errorf("unknown archive format: exit code %d, want %d", code, ExitError)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	cfg := newTestNetwork(t)
+
+	if code, output := run(t, cfg, "peers"); code != ExitOK || !slices.Contains(strings.Fields(output), "bob") {
+		t.Errorf("peers: exit code %d, output %q", code, output)
+	}
+	if code, output := run(t, cfg, "ls", "bob"); code != ExitOK || output != "docs/\nhello.txt\n" {
+		t.Errorf("ls: exit code %d, output %q", code, output)
+	}
+	if code, output := run(t, cfg, "cat", "bob", "docs/notes.txt"); code != ExitOK || output != "notes\n" {
+		t.Errorf("cat: exit code %d, output %q", code, output)
+	}
+	if code, _ := run(t, cfg, "cat", "bob", "missing.txt"); code != ExitNotFound {
+		t.Errorf("cat of a missing file: exit code %d, want %d", code, ExitNotFound)
+	}
+	if code, _ := run(t, cfg, "ls", "carol"); code != ExitNotFound {
+		t.Errorf("ls of an unknown peer: exit code %d, want %d", code, ExitNotFound)
+	}
+
+	dest := filepath.Join(t.TempDir(), "hello.txt")
+	if code, _ := run(t, cfg, "get", "bob", "hello.txt", dest); code != ExitOK {
+		t.Fatalf("get: exit code %d", code)
+	}
+	if content, err := os.ReadFile(dest); err != nil || string(content) != "hello\n" {
+		t.Errorf("downloaded %q, %v", content, err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "docs.zip")
+	if code, _ := run(t, cfg, "archive", "-format", "zip", "-o", archive, "bob", "docs"); code != ExitOK {
+		t.Fatalf("archive: exit code %d", code)
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+	if !slices.Equal(names, []string{"docs/", "docs/notes.txt"}) {
+		t.Errorf("archive holds %v", names)
+	}
+}
@@ -0,0 +1,376 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"protocoles-internet-2023/archive"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/node"
+	"syscall"
+	"time"
+)
+
+// interval between two progress requests while downloading
+const pollInterval = 200 * time.Millisecond
+
+func peersCommand(s *session, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	peers, err := client.Peers()
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		fmt.Println(peer.Name)
+	}
+
+	return nil
+}
+
+func serverCommand(s *session, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	// a node started for the command did not register yet
+	if s.node != nil {
+		s.node.HelloToServer()
+	}
+
+	status, err := client.Server()
+	if err != nil {
+		return err
+	}
+
+	if !status.Healthy && status.Error != "" {
+		return errors.New("not associated with the server: " + status.Error)
+	} else if !status.Healthy {
+		return errors.New("not associated with the server")
+	}
+	if !status.Registered {
+		return errors.New("associated with " + status.Address + " but not registered")
+	}
+	fmt.Printf("registered with %s, last answer %s\n", status.Address, status.LastSuccess)
+
+	return nil
+}
+
+func connectCommand(s *session, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	peer, err := client.Connect(args[0])
+	if err != nil {
+		return err
+	}
+
+	transport := "in clear"
+	if peer.Encrypted {
+		transport = "encrypted"
+	}
+	fmt.Printf("%s (%s): %s, %s, root %s\n", peer.Name, peer.Address, peer.State, transport, peer.Root)
+
+	return nil
+}
+
+func helloCommand(s *session, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	peer, err := client.Hello(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s) answered in %d ms\n", peer.Name, peer.Address, peer.RTT)
+
+	return nil
+}
+
+func rootCommand(s *session, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	root, err := client.List(args[0], "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(root.Hash)
+
+	return nil
+}
+
+func lsCommand(s *session, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+
+	filePath := ""
+	if len(args) == 2 {
+		filePath = args[1]
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	listing, err := client.List(args[0], filePath)
+	if err != nil {
+		return err
+	}
+
+	if listing.Type != control.DirectoryEntry {
+		fmt.Println(path.Base(listing.Path))
+		return nil
+	}
+
+	for _, entry := range listing.Entries {
+		if entry.Type == control.DirectoryEntry {
+			fmt.Println(entry.Name + "/")
+		} else {
+			fmt.Println(entry.Name)
+		}
+	}
+
+	return nil
+}
+
+func catCommand(s *session, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	return client.Cat(os.Stdout, args[0], args[1])
+}
+
+/*
+Downloads a file or a whole directory to dest, the download is done by the
+node and canceled if the command is interrupted
+*/
+func getCommand(s *session, args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	// the node may run in another directory
+	dest, err := filepath.Abs(args[2])
+	if err != nil {
+		return err
+	}
+
+	download, err := client.StartDownload(control.DownloadRequest{
+		Peer: args[0],
+		Path: args[1],
+		Dest: dest,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for download.State == control.Running {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if _, err := client.CancelDownload(download.Id); err != nil {
+				return err
+			}
+			return errors.New("interrupted")
+		}
+
+		download, err = client.Download(download.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if download.State != control.Done {
+		return errors.New("download " + download.State + ": " + download.Error)
+	}
+
+	return nil
+}
+
+func archiveCommand(s *session, args []string) error {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	format := flags.String("format", archive.Tar, "archive format: tar or zip")
+	output := flags.String("o", "-", "destination file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 1 && flags.NArg() != 2 {
+		return errUsage
+	}
+	if *format != archive.Tar && *format != archive.Zip {
+		return errors.New("unknown archive format: " + *format)
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return client.Archive(out, flags.Arg(0), flags.Arg(1), *format)
+}
+
+/*
+Makes the running node export the directory (or tar/zip archive) instead
+of its current exports, or exports it until interrupted if no node runs
+*/
+func exportCommand(s *session, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	export, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(export); err != nil {
+		return err
+	}
+
+	if client, err := control.Dial(s.config.ControlSocketPath()); err == nil {
+		exports, err := client.SetExports([]string{export})
+		if err != nil {
+			return err
+		}
+		fmt.Println(exports.Root)
+		return nil
+	}
+
+	cfg := s.config
+	cfg.Exports = []string{export}
+
+	exportNode, err := node.NewNode(cfg)
+	if err != nil {
+		return err
+	}
+	s.node = exportNode
+
+	s.server = control.NewServer(exportNode, cfg.DownloadDir)
+	if err = s.server.Listen(cfg.ControlSocketPath()); err != nil {
+		return err
+	}
+
+	exportNode.Start()
+	fmt.Println(hex.EncodeToString(exportNode.Scheduler.Exports().Hash[:]))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	return nil
+}
+
+func knownPeersCommand(s *session, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	peers, err := client.KnownPeers()
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		fmt.Printf("%s %s first seen %s, last seen %s\n", peer.Name, peer.Fingerprint,
+			peer.FirstSeen.Format(time.DateTime), peer.LastSeen.Format(time.DateTime))
+		if peer.Pending != "" {
+			fmt.Printf("  KEY CHANGED, not trusted: %s\n", peer.PendingFingerprint)
+		}
+	}
+
+	return nil
+}
+
+func acceptKeyCommand(s *session, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	peer, err := client.AcceptKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now trusted with key %s\n", peer.Name, peer.Fingerprint)
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/crypto"
+)
+
+var errKeyExists = errors.New("the store already has a key, -force replaces it (rotate also sends the new one to the server)")
+
+/*
+keys generate|import|export|fingerprint|rotate, all but rotate work on the
+key store directly
+*/
+func keysCommand(s *session, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	passphrase, err := s.config.KeyPassphrase()
+	if err != nil {
+		return err
+	}
+	store := crypto.KeyStore{
+		Path:       s.config.KeyStore,
+		Passphrase: passphrase,
+	}
+
+	switch args[0] {
+	case "generate":
+		return generateKey(s, store, args[1:])
+	case "import":
+		return importKey(s, store, args[1:])
+	case "export":
+		return exportKey(store, args[1:])
+	case "fingerprint":
+		if len(args) != 1 {
+			return errUsage
+		}
+		publicKey, err := store.PublicKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(crypto.Fingerprint(crypto.FormatPublicKey(*publicKey)))
+		return nil
+	case "rotate":
+		if len(args) != 1 {
+			return errUsage
+		}
+		client, err := s.client()
+		if err != nil {
+			return err
+		}
+		keys, err := client.RotateKeys()
+		if err != nil {
+			return err
+		}
+		fmt.Println(keys.Fingerprint)
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+// a key is only replaced on purpose
+func checkReplace(store crypto.KeyStore, force bool) error {
+	_, err := store.PublicKey()
+	if err == nil && !force {
+		return errKeyExists
+	} else if err != nil && !errors.Is(err, crypto.ErrNoStoredKey) {
+		return err
+	}
+	return nil
+}
+
+// the running node keeps the key it loaded
+func warnRunningNode(s *session) {
+	if _, err := control.Dial(s.config.ControlSocketPath()); err == nil {
+		fmt.Fprintln(os.Stderr, "a node is running with the previous key, restart it to use the new one")
+	}
+}
+
+func generateKey(s *session, store crypto.KeyStore, args []string) error {
+	flags := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace the key of the store")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := checkReplace(store, *force); err != nil {
+		return err
+	}
+
+	privateKey, _, err := crypto.GenerateKeys()
+	if err != nil {
+		return err
+	}
+	if err = store.Save(privateKey); err != nil {
+		return err
+	}
+
+	fmt.Println(crypto.Fingerprint(crypto.FormatPublicKey(privateKey.PublicKey)))
+	warnRunningNode(s)
+
+	return nil
+}
+
+func importKey(s *session, store crypto.KeyStore, args []string) error {
+	flags := flag.NewFlagSet("keys import", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace the key of the store")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	privateKey, err := crypto.DecodePrivateKey(data)
+	if err != nil {
+		return errors.New(flags.Arg(0) + ": " + err.Error())
+	}
+
+	if err = checkReplace(store, *force); err != nil {
+		return err
+	}
+	if err = store.Save(privateKey); err != nil {
+		return err
+	}
+
+	fmt.Println(crypto.Fingerprint(crypto.FormatPublicKey(privateKey.PublicKey)))
+	warnRunningNode(s)
+
+	return nil
+}
+
+/*
+Writes the public key as PEM, or the private key in clear with -private
+*/
+func exportKey(store crypto.KeyStore, args []string) error {
+	flags := flag.NewFlagSet("keys export", flag.ContinueOnError)
+	private := flags.Bool("private", false, "export the private key, in clear")
+	output := flags.String("o", "-", "destination file, - for stdout")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	var block *pem.Block
+	if *private {
+		privateKey, err := store.Load()
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		fmt.Fprintln(os.Stderr, "WARNING: the private key is written in clear, anyone reading it can impersonate this peer")
+	} else {
+		publicKey, err := store.PublicKey()
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return pem.Encode(out, block)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/directory"
+	"protocoles-internet-2023/logging"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var logger = logging.Logger("main")
+
+// flag.Value for options given several times
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+/*
+Directory server for a network on one machine or a local network, in place
+of jch.irif.fr. The peers are started with -endpoint set to the REST address,
+e.g. http://localhost:8443
+*/
+func main() {
+	var advertise stringList
+	name := flag.String("name", "jch.irif.fr", "name of the server among the peers")
+	listen := flag.String("listen", ":8443", "UDP address the peers register on")
+	rest := flag.String("rest", "localhost:8443", "TCP address of the REST server")
+	certFile := flag.String("tls-cert", "", "PEM certificate of the REST server, plain HTTP without it")
+	keyFile := flag.String("tls-key", "", "PEM private key of the certificate")
+	keys := flag.String("keys", "", "key store of the server, a new key at every start without it")
+	flag.Var(&advertise, "advertise", "UDP address published for the server, host:port, can be given several times (default the listening address)")
+	logLevel := flag.String("log-level", "info", "trace, debug, info, warn or error")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logging.SetLevel(level)
+
+	if (*certFile == "") != (*keyFile == "") {
+		fmt.Fprintln(os.Stderr, "-tls-cert and -tls-key go together")
+		os.Exit(2)
+	}
+
+	for _, address := range advertise {
+		if _, err := net.ResolveUDPAddr("udp", address); err != nil {
+			fmt.Fprintln(os.Stderr, "advertised address: "+err.Error())
+			os.Exit(2)
+		}
+	}
+
+	var privateKey *ecdsa.PrivateKey
+	if *keys != "" {
+		if privateKey, _, err = crypto.LoadFromDisk(*keys, ""); err != nil {
+			logger.Error("could not load the keys", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	srv, err := directory.NewServer(*name, *listen, privateKey)
+	if err != nil {
+		logger.Error("could not start the server", "err", err)
+		os.Exit(1)
+	}
+	if len(advertise) != 0 {
+		srv.Addresses = advertise
+	}
+	srv.Start()
+	defer srv.Close()
+
+	httpServer := &http.Server{Addr: *rest, Handler: srv}
+	go func() {
+		logger.Info("serving REST", "addr", *rest, "tls", *certFile != "")
+		var err error
+		if *certFile != "" {
+			err = httpServer.ListenAndServeTLS(*certFile, *keyFile)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("REST server stopped", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("REST server shutdown", "err", err)
+	}
+}
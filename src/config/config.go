@@ -1,13 +1,14 @@
 package config
 
-var Debug = true
-var DebugSpam = false
+import "time"
+
 var ClientName = "ogu"
 
-func SetDebug(val bool) {
-	Debug = val
-}
+// first retransmission delay of a request, doubled after every loss
+var RequestTimeout = time.Second
+
+// number of times a request is sent before giving up
+var RequestRetries = 3
 
-func SetDebugSpam(val bool) {
-	DebugSpam = val
-}
+// timeout of the requests to the REST server
+var RESTTimeout = 50 * time.Second
@@ -0,0 +1,538 @@
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix of the environment variables, e.g. P2P_ENDPOINT
+const EnvPrefix = "P2P_"
+
+/*
+Settings of the client, read in this order, each source overriding the
+previous one: defaults, JSON configuration file, environment, command line
+*/
+type Config struct {
+	Endpoint        string   `json:"endpoint"`
+	ServerName      string   `json:"server_name"`      // name of the directory server among the peers
+	ServerAddresses []string `json:"server_addresses"` // UDP addresses of the server, empty to ask the REST server
+	PeerName        string   `json:"name"`
+	Exports         []string `json:"exports"`
+	ListenAddress   string   `json:"listen"` // host:port, empty for any address and a random port
+	KeyStore        string   `json:"keys"`
+	PassphraseFile  string   `json:"passphrase_file"` // passphrase of the private key on its first line
+	Passphrase      string   `json:"-"`               // only from the environment, never written in a file
+	Signatures      string   `json:"signatures"`      // off, verify-if-present, required-for-handshake or required-for-all
+	KnownPeers      string   `json:"known_peers"`     // keys of the peers pinned on first use, empty to trust any key
+	Encryption      string   `json:"encryption"`      // off, if-available or required
+	Access          Access   `json:"access"`          // only read from the configuration file
+	DownloadDir     string   `json:"downloads"`
+	RequestTimeout  Duration `json:"request_timeout"`
+	RequestRetries  int      `json:"request_retries"`
+	RESTTimeout     Duration `json:"rest_timeout"`
+	RESTCA          string   `json:"rest_ca"`    // PEM certificates trusting the REST server instead of the system roots
+	RESTPins        []string `json:"rest_pins"`  // SHA-256 of the public keys the REST server may present, hex
+	RESTCache       Duration `json:"rest_cache"` // answers of the REST server are not asked again for that long
+	Gateway         string   `json:"gateway"`
+	ControlSocket   string   `json:"control_socket"` // empty for a socket named after the peer in the temporary directory
+	LogFile         string   `json:"log_file"`
+	LogLevel        string   `json:"log_level"`  // trace, debug, info, warn or error, overrides debug and debug_spam
+	LogFormat       string   `json:"log_format"` // text or json
+	Debug           bool     `json:"debug"`      // same as log_level debug
+	DebugSpam       bool     `json:"debug_spam"` // same as log_level trace
+}
+
+/*
+Who may read our exports, peers are designated by the fingerprint of their
+verified public key, as listed by known-peers
+Shares are the top-level entries of the exported tree, one per export when
+there are several
+*/
+type Access struct {
+	Allow  []string            `json:"allow,omitempty"`  // only these peers may read, everyone when empty
+	Deny   []string            `json:"deny,omitempty"`   // these peers may not read anything
+	Shares map[string][]string `json:"shares,omitempty"` // share visible to these peers only, the others are public
+}
+
+// time.Duration written as "1s", "500ms"... in the configuration file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("duration must be a string such as \"2s\"")
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func Default() Config {
+	return Config{
+		Endpoint:       "https://jch.irif.fr:8443",
+		ServerName:     "jch.irif.fr",
+		PeerName:       ClientName,
+		Exports:        []string{"test_arborescence"},
+		KeyStore:       "keys.db",
+		Signatures:     string(crypto.PolicyRequiredForHandshake),
+		KnownPeers:     "known_peers.json",
+		Encryption:     string(crypto.EncryptionIfAvailable),
+		DownloadDir:    "..",
+		RequestTimeout: Duration{RequestTimeout},
+		RequestRetries: RequestRetries,
+		RESTTimeout:    Duration{RESTTimeout},
+		RESTCache:      Duration{5 * time.Second},
+		LogFormat:      logging.Text,
+		Debug:          true,
+	}
+}
+
+// flag.Value for options given several times, e.g. -export a -export b
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+/*
+Registers the configuration options on flags, parses args and builds the
+configuration from every source
+The positional arguments are left in flags.Args()
+*/
+func Load(flags *flag.FlagSet, args []string) (Config, error) {
+
+	cfg := Default()
+
+	configFile := flags.String("config", os.Getenv(EnvPrefix+"CONFIG"), "JSON configuration file")
+
+	var fromFlags Config
+	var exports, serverAddresses, restPins stringList
+	flags.StringVar(&fromFlags.Endpoint, "endpoint", "", "URL of the REST server (default "+cfg.Endpoint+")")
+	flags.StringVar(&fromFlags.ServerName, "server-name", "", "name of the directory server among the peers (default "+cfg.ServerName+")")
+	flags.Var(&serverAddresses, "server-address", "UDP address of the directory server, host:port, can be given several times (default the addresses published by the REST server)")
+	flags.StringVar(&fromFlags.PeerName, "name", "", "name of the peer (default "+cfg.PeerName+")")
+	flags.Var(&exports, "export", "directory, tar or zip archive to export, can be given several times (default "+strings.Join(cfg.Exports, ",")+")")
+	flags.StringVar(&fromFlags.ListenAddress, "listen", "", "UDP address to listen on, host:port (default random port)")
+	flags.StringVar(&fromFlags.KeyStore, "keys", "", "key store file (default "+cfg.KeyStore+")")
+	flags.StringVar(&fromFlags.PassphraseFile, "passphrase-file", "", "file holding the passphrase of the private key (or "+EnvPrefix+"KEY_PASSPHRASE)")
+	flags.StringVar(&fromFlags.Signatures, "signatures", "", "signature policy: off, verify-if-present, required-for-handshake or required-for-all (default "+cfg.Signatures+")")
+	flags.StringVar(&fromFlags.KnownPeers, "known-peers", "", "file of the peer keys pinned on first use, empty to trust any key (default "+cfg.KnownPeers+")")
+	flags.StringVar(&fromFlags.Encryption, "encryption", "", "encryption of the datums exchanged with peers: off, if-available or required (default "+cfg.Encryption+")")
+	flags.StringVar(&fromFlags.DownloadDir, "downloads", "", "directory where the node saves downloads (default "+cfg.DownloadDir+")")
+	flags.DurationVar(&fromFlags.RequestTimeout.Duration, "timeout", 0, "first retransmission delay of UDP requests (default "+cfg.RequestTimeout.String()+")")
+	flags.IntVar(&fromFlags.RequestRetries, "retries", 0, "number of sends of a UDP request before giving up (default "+strconv.Itoa(cfg.RequestRetries)+")")
+	flags.DurationVar(&fromFlags.RESTTimeout.Duration, "rest-timeout", 0, "timeout of REST requests (default "+cfg.RESTTimeout.String()+")")
+	flags.StringVar(&fromFlags.RESTCA, "rest-ca", "", "PEM certificates trusting the REST server instead of the system roots")
+	flags.Var(&restPins, "rest-pin", "hex SHA-256 of a public key the REST server may present, can be given several times")
+	flags.DurationVar(&fromFlags.RESTCache.Duration, "rest-cache", 0, "how long answers of the REST server are reused, 0 to always ask (default "+cfg.RESTCache.String()+")")
+	flags.StringVar(&fromFlags.Gateway, "gateway", "", "serve peers' files over HTTP on this local address (e.g. localhost:8080)")
+	flags.StringVar(&fromFlags.ControlSocket, "control", "", "Unix socket of the control API (default "+cfg.ControlSocketPath()+")")
+	flags.StringVar(&fromFlags.LogFile, "log", "", "write logs to this file instead of stdout")
+	flags.StringVar(&fromFlags.LogLevel, "log-level", "", "trace, debug, info, warn or error (default debug, or trace with -debug-spam)")
+	flags.StringVar(&fromFlags.LogFormat, "log-format", "", "format of the logs, text or json (default "+cfg.LogFormat+")")
+	flags.BoolVar(&fromFlags.Debug, "debug", cfg.Debug, "log debug messages")
+	flags.BoolVar(&fromFlags.DebugSpam, "debug-spam", cfg.DebugSpam, "log a message for every packet")
+
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return Config{}, err
+	}
+
+	// only the options given on the command line override the other sources
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "endpoint":
+			cfg.Endpoint = fromFlags.Endpoint
+		case "server-name":
+			cfg.ServerName = fromFlags.ServerName
+		case "server-address":
+			cfg.ServerAddresses = serverAddresses
+		case "name":
+			cfg.PeerName = fromFlags.PeerName
+		case "export":
+			cfg.Exports = exports
+		case "listen":
+			cfg.ListenAddress = fromFlags.ListenAddress
+		case "keys":
+			cfg.KeyStore = fromFlags.KeyStore
+		case "passphrase-file":
+			cfg.PassphraseFile = fromFlags.PassphraseFile
+		case "signatures":
+			cfg.Signatures = fromFlags.Signatures
+		case "known-peers":
+			cfg.KnownPeers = fromFlags.KnownPeers
+		case "encryption":
+			cfg.Encryption = fromFlags.Encryption
+		case "downloads":
+			cfg.DownloadDir = fromFlags.DownloadDir
+		case "timeout":
+			cfg.RequestTimeout = fromFlags.RequestTimeout
+		case "retries":
+			cfg.RequestRetries = fromFlags.RequestRetries
+		case "rest-timeout":
+			cfg.RESTTimeout = fromFlags.RESTTimeout
+		case "rest-ca":
+			cfg.RESTCA = fromFlags.RESTCA
+		case "rest-pin":
+			cfg.RESTPins = restPins
+		case "rest-cache":
+			cfg.RESTCache = fromFlags.RESTCache
+		case "gateway":
+			cfg.Gateway = fromFlags.Gateway
+		case "control":
+			cfg.ControlSocket = fromFlags.ControlSocket
+		case "log":
+			cfg.LogFile = fromFlags.LogFile
+		case "log-level":
+			cfg.LogLevel = fromFlags.LogLevel
+		case "log-format":
+			cfg.LogFormat = fromFlags.LogFormat
+		case "debug":
+			cfg.Debug = fromFlags.Debug
+		case "debug-spam":
+			cfg.DebugSpam = fromFlags.DebugSpam
+		}
+	})
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("reading configuration file: " + err.Error())
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("configuration file %s: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	var err error
+
+	env := func(name string) (string, bool) {
+		return os.LookupEnv(EnvPrefix + name)
+	}
+	invalid := func(name string, cause error) error {
+		return fmt.Errorf("environment variable %s%s: %s", EnvPrefix, name, cause.Error())
+	}
+
+	if value, ok := env("ENDPOINT"); ok {
+		cfg.Endpoint = value
+	}
+	if value, ok := env("SERVER_NAME"); ok {
+		cfg.ServerName = value
+	}
+	if value, ok := env("SERVER_ADDRESSES"); ok {
+		cfg.ServerAddresses = strings.Split(value, ",")
+	}
+	if value, ok := env("NAME"); ok {
+		cfg.PeerName = value
+	}
+	if value, ok := env("EXPORTS"); ok {
+		cfg.Exports = strings.Split(value, string(os.PathListSeparator))
+	}
+	if value, ok := env("LISTEN"); ok {
+		cfg.ListenAddress = value
+	}
+	if value, ok := env("KEYS"); ok {
+		cfg.KeyStore = value
+	}
+	if value, ok := env("PASSPHRASE_FILE"); ok {
+		cfg.PassphraseFile = value
+	}
+	if value, ok := env("KEY_PASSPHRASE"); ok {
+		cfg.Passphrase = value
+	}
+	if value, ok := env("SIGNATURES"); ok {
+		cfg.Signatures = value
+	}
+	if value, ok := env("KNOWN_PEERS"); ok {
+		cfg.KnownPeers = value
+	}
+	if value, ok := env("ENCRYPTION"); ok {
+		cfg.Encryption = value
+	}
+	if value, ok := env("DOWNLOADS"); ok {
+		cfg.DownloadDir = value
+	}
+	if value, ok := env("REQUEST_TIMEOUT"); ok {
+		if cfg.RequestTimeout.Duration, err = time.ParseDuration(value); err != nil {
+			return invalid("REQUEST_TIMEOUT", err)
+		}
+	}
+	if value, ok := env("REQUEST_RETRIES"); ok {
+		if cfg.RequestRetries, err = strconv.Atoi(value); err != nil {
+			return invalid("REQUEST_RETRIES", err)
+		}
+	}
+	if value, ok := env("REST_TIMEOUT"); ok {
+		if cfg.RESTTimeout.Duration, err = time.ParseDuration(value); err != nil {
+			return invalid("REST_TIMEOUT", err)
+		}
+	}
+	if value, ok := env("REST_CA"); ok {
+		cfg.RESTCA = value
+	}
+	if value, ok := env("REST_PINS"); ok {
+		cfg.RESTPins = strings.Split(value, ",")
+	}
+	if value, ok := env("REST_CACHE"); ok {
+		if cfg.RESTCache.Duration, err = time.ParseDuration(value); err != nil {
+			return invalid("REST_CACHE", err)
+		}
+	}
+	if value, ok := env("GATEWAY"); ok {
+		cfg.Gateway = value
+	}
+	if value, ok := env("CONTROL_SOCKET"); ok {
+		cfg.ControlSocket = value
+	}
+	if value, ok := env("LOG_FILE"); ok {
+		cfg.LogFile = value
+	}
+	if value, ok := env("LOG_LEVEL"); ok {
+		cfg.LogLevel = value
+	}
+	if value, ok := env("LOG_FORMAT"); ok {
+		cfg.LogFormat = value
+	}
+	if value, ok := env("DEBUG"); ok {
+		if cfg.Debug, err = strconv.ParseBool(value); err != nil {
+			return invalid("DEBUG", err)
+		}
+	}
+	if value, ok := env("DEBUG_SPAM"); ok {
+		if cfg.DebugSpam, err = strconv.ParseBool(value); err != nil {
+			return invalid("DEBUG_SPAM", err)
+		}
+	}
+
+	return nil
+}
+
+/*
+Passphrase of the private key, from the environment or else the first line
+of the passphrase file, empty if there is none: the key is then stored in
+clear
+*/
+func (cfg *Config) KeyPassphrase() (string, error) {
+	if cfg.Passphrase != "" || cfg.PassphraseFile == "" {
+		return cfg.Passphrase, nil
+	}
+
+	data, err := os.ReadFile(cfg.PassphraseFile)
+	if err != nil {
+		return "", errors.New("reading passphrase: " + err.Error())
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return "", errors.New("passphrase file " + cfg.PassphraseFile + " is empty")
+	}
+
+	return passphrase, nil
+}
+
+/*
+Path of the Unix socket on which a running node serves the control API,
+the CLI finds it there as long as it is given the same configuration
+*/
+func (cfg *Config) ControlSocketPath() string {
+	if cfg.ControlSocket != "" {
+		return cfg.ControlSocket
+	}
+	return filepath.Join(os.TempDir(), "p2p-"+cfg.PeerName+".sock")
+}
+
+/*
+Checks the settings that do not depend on the files present on the disk
+*/
+func (cfg *Config) Validate() error {
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return errors.New("endpoint must be an http(s) URL, got \"" + cfg.Endpoint + "\"")
+	}
+
+	if cfg.ServerName == "" {
+		return errors.New("server name must not be empty")
+	}
+	for _, address := range cfg.ServerAddresses {
+		if _, err := net.ResolveUDPAddr("udp", address); err != nil {
+			return errors.New("server address: " + err.Error())
+		}
+	}
+
+	if cfg.PeerName == "" {
+		return errors.New("peer name must not be empty")
+	}
+	if strings.ContainsAny(cfg.PeerName, "\n\r/") {
+		return errors.New("peer name must not contain new lines or slashes")
+	}
+
+	if cfg.ListenAddress != "" {
+		if _, err := net.ResolveUDPAddr("udp", cfg.ListenAddress); err != nil {
+			return errors.New("listen address: " + err.Error())
+		}
+	}
+
+	if cfg.KeyStore == "" {
+		return errors.New("key store path must not be empty")
+	}
+
+	if _, err := crypto.ParsePolicy(cfg.Signatures); err != nil {
+		return err
+	}
+	if _, err := crypto.ParseEncryption(cfg.Encryption); err != nil {
+		return err
+	}
+
+	if err := cfg.Access.validate(); err != nil {
+		return err
+	}
+
+	if cfg.RequestTimeout.Duration <= 0 {
+		return errors.New("request timeout must be positive")
+	}
+	if cfg.RequestRetries < 1 {
+		return errors.New("request retries must be at least 1")
+	}
+	if cfg.RESTTimeout.Duration <= 0 {
+		return errors.New("REST timeout must be positive")
+	}
+	for _, pin := range cfg.RESTPins {
+		if _, err := hex.DecodeString(pin); err != nil || len(pin) != 64 {
+			return errors.New("REST pin \"" + pin + "\" is not a SHA-256 (64 hexadecimal digits)")
+		}
+	}
+	if cfg.RESTCache.Duration < 0 {
+		return errors.New("REST cache duration must not be negative")
+	}
+
+	if cfg.Gateway != "" {
+		if _, _, err := net.SplitHostPort(cfg.Gateway); err != nil {
+			return errors.New("gateway address: " + err.Error())
+		}
+	}
+
+	if cfg.LogLevel != "" {
+		if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+	if cfg.LogFormat != logging.Text && cfg.LogFormat != logging.JSON {
+		return errors.New("log format must be text or json, got \"" + cfg.LogFormat + "\"")
+	}
+
+	return nil
+}
+
+/*
+Level of the logs, an explicit log level wins over the debug options
+*/
+func (cfg *Config) Level() slog.Level {
+	if level, err := logging.ParseLevel(cfg.LogLevel); err == nil && cfg.LogLevel != "" {
+		return level
+	}
+
+	switch {
+	case cfg.DebugSpam:
+		return logging.LevelTrace
+	case cfg.Debug:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+/*
+Checks that the exported paths and the download directory exist, only
+needed when the node exports files or downloads them from the GUI
+*/
+func (cfg *Config) ValidatePaths() error {
+
+	for _, export := range cfg.Exports {
+		if _, err := os.Stat(export); err != nil {
+			return errors.New("export: " + err.Error())
+		}
+	}
+
+	if fileInfo, err := os.Stat(cfg.DownloadDir); err != nil {
+		return errors.New("download directory: " + err.Error())
+	} else if !fileInfo.IsDir() {
+		return errors.New("download directory: " + cfg.DownloadDir + " is not a directory")
+	}
+
+	return nil
+}
+
+/*
+Makes the configuration effective for the packages reading the globals
+*/
+func (cfg *Config) Apply() {
+	ClientName = cfg.PeerName
+	RequestTimeout = cfg.RequestTimeout.Duration
+	RequestRetries = cfg.RequestRetries
+	RESTTimeout = cfg.RESTTimeout.Duration
+	logging.SetLevel(cfg.Level())
+	logging.SetFormat(cfg.LogFormat)
+}
+
+func (access Access) validate() error {
+	fingerprints := append(append([]string{}, access.Allow...), access.Deny...)
+	for _, peers := range access.Shares {
+		fingerprints = append(fingerprints, peers...)
+	}
+
+	for _, fingerprint := range fingerprints {
+		if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != 64 {
+			return errors.New("access: \"" + fingerprint + "\" is not a key fingerprint (64 hexadecimal digits)")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(args ...string) (Config, error) {
+	flags := flag.NewFlagSet("p2p", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return Load(flags, args)
+}
+
+// the file overrides the defaults, the environment the file and the flags everything
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "p2p.json", `{
+		"name": "from-file",
+		"exports": ["a", "b"],
+		"request_timeout": "3s",
+		"retries_unknown": 0
+	}`)
+	if _, err := load("-config", file); err == nil || !strings.Contains(err.Error(), "retries_unknown") {
+		t.Fatalf("unknown field in the file: %v", err)
+	}
+
+	file = writeFile(t, "p2p.json", `{
+		"name": "from-file",
+		"exports": ["a", "b"],
+		"request_timeout": "3s",
+		"encryption": "required"
+	}`)
+	t.Setenv(EnvPrefix+"CONFIG", file)
+	t.Setenv(EnvPrefix+"NAME", "from-env")
+	t.Setenv(EnvPrefix+"REQUEST_RETRIES", "7")
+
+	cfg, err := load("-name", "from-flag", "-export", "c", "-export", "d", "get", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PeerName != "from-flag" {
+		t.Errorf("name %q, want the one of the flag", cfg.PeerName)
+	}
+	if !slices.Equal(cfg.Exports, []string{"c", "d"}) {
+		t.Errorf("exports %v, want those of the flags", cfg.Exports)
+	}
+	if cfg.RequestTimeout.Duration != 3*time.Second || cfg.Encryption != "required" {
+		t.Errorf("timeout %s and encryption %s, want those of the file", cfg.RequestTimeout, cfg.Encryption)
+	}
+	if cfg.RequestRetries != 7 {
+		t.Errorf("%d retries, want those of the environment", cfg.RequestRetries)
+	}
+	if cfg.KeyStore != Default().KeyStore {
+		t.Errorf("key store %q, want the default", cfg.KeyStore)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	t.Setenv(EnvPrefix+"REQUEST_RETRIES", "many")
+	if _, err = load(); err == nil || !strings.Contains(err.Error(), EnvPrefix+"REQUEST_RETRIES") {
+		t.Errorf("invalid environment variable: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if cfg := Default(); cfg.Validate() != nil {
+		t.Fatalf("default configuration invalid: %v", cfg.Validate())
+	}
+
+	invalid := map[string]func(*Config){
+		"endpoint":       func(cfg *Config) { cfg.Endpoint = "ftp://jch.irif.fr" },
+		"name":           func(cfg *Config) { cfg.PeerName = "a/b" },
+		"listen":         func(cfg *Config) { cfg.ListenAddress = "localhost" },
+		"signatures":     func(cfg *Config) { cfg.Signatures = "sometimes" },
+		"encryption":     func(cfg *Config) { cfg.Encryption = "maybe" },
+		"access":         func(cfg *Config) { cfg.Access.Allow = []string{"alice"} },
+		"timeout":        func(cfg *Config) { cfg.RequestTimeout.Duration = 0 },
+		"retries":        func(cfg *Config) { cfg.RequestRetries = 0 },
+		"REST pin":       func(cfg *Config) { cfg.RESTPins = []string{"abcd"} },
+		"gateway":        func(cfg *Config) { cfg.Gateway = "8080" },
+		"log level":      func(cfg *Config) { cfg.LogLevel = "loud" },
+		"log format":     func(cfg *Config) { cfg.LogFormat = "xml" },
+		"server address": func(cfg *Config) { cfg.ServerAddresses = []string{"jch.irif.fr"} },
+	}
+	for name, change := range invalid {
+		cfg := Default()
+		change(&cfg)
+		if cfg.Validate() == nil {
+			t.Errorf("invalid %s accepted", name)
+		}
+	}
+}
+
+func TestKeyPassphrase(t *testing.T) {
+	cfg := Default()
+	if passphrase, err := cfg.KeyPassphrase(); passphrase != "" || err != nil {
+		t.Errorf("no passphrase: got %q, %v", passphrase, err)
+	}
+
+	cfg.PassphraseFile = writeFile(t, "passphrase", "correct horse\r\nsecond line\n")
+	if passphrase, err := cfg.KeyPassphrase(); passphrase != "correct horse" || err != nil {
+		t.Errorf("from the file: got %q, %v", passphrase, err)
+	}
+
+	cfg.Passphrase = "battery staple"
+	if passphrase, _ := cfg.KeyPassphrase(); passphrase != "battery staple" {
+		t.Errorf("the environment should win over the file, got %q", passphrase)
+	}
+
+	cfg.Passphrase = ""
+	cfg.PassphraseFile = writeFile(t, "passphrase", "\n")
+	if _, err := cfg.KeyPassphrase(); err == nil {
+		t.Error("empty passphrase file accepted")
+	}
+}
+
+func TestLevel(t *testing.T) {
+	cfg := Default()
+	cfg.Debug = false
+	if cfg.Level() != slog.LevelInfo {
+		t.Errorf("level %s, want info", cfg.Level())
+	}
+	cfg.Debug = true
+	if cfg.Level() != slog.LevelDebug {
+		t.Errorf("level %s with debug, want debug", cfg.Level())
+	}
+	cfg.LogLevel = "warn"
+	if cfg.Level() != slog.LevelWarn {
+		t.Errorf("level %s, want the explicit one", cfg.Level())
+	}
+}
@@ -0,0 +1,293 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+Client of the control API of a node, used by the CLI and the GUI
+*/
+type Client struct {
+	http *http.Client
+}
+
+/*
+Error answered by the node, the errors of the server are recognizable
+with errors.Is: ErrUnknownPeer for an unknown peer, ErrNotFound for any
+other missing peer, path or download
+*/
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (err *APIError) Error() string {
+	return err.Message
+}
+
+func (err *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return err.Status == http.StatusNotFound
+	case ErrUnknownPeer:
+		return err.Status == http.StatusNotFound && strings.HasPrefix(err.Message, ErrUnknownPeer.Error())
+	case ErrKeyChanged:
+		return err.Status == http.StatusConflict
+	}
+	return false
+}
+
+/*
+Connects to the node serving its control API on the socket, fails if no
+node is running there
+*/
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &Client{http: &http.Client{Transport: transport}}, nil
+}
+
+/*
+Escapes every element of a slash separated path
+*/
+func escapePath(filePath string) string {
+	names := strings.Split(strings.Trim(filePath, "/"), "/")
+	for i, name := range names {
+		names[i] = url.PathEscape(name)
+	}
+	return strings.Join(names, "/")
+}
+
+/*
+Sends the request and returns the response if its status is a success
+The host is ignored, the connection always goes to the socket
+*/
+func (client *Client) do(method string, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, "http://node"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := client.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+
+		var answer errorResponse
+		if err := json.NewDecoder(response.Body).Decode(&answer); err != nil || answer.Error == "" {
+			answer.Error = response.Status
+		}
+		return nil, &APIError{Status: response.StatusCode, Message: answer.Error}
+	}
+
+	return response, nil
+}
+
+func (client *Client) call(method string, path string, body any, result any) error {
+	response, err := client.do(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+/*
+Peers registered on the server, with what the node knows of them
+*/
+func (client *Client) Peers() ([]PeerStatus, error) {
+	var peers []PeerStatus
+	err := client.call(http.MethodGet, "/peers", nil, &peers)
+	return peers, err
+}
+
+func (client *Client) Peer(name string) (PeerStatus, error) {
+	var peer PeerStatus
+	err := client.call(http.MethodGet, "/peers/"+url.PathEscape(name), nil, &peer)
+	return peer, err
+}
+
+func (client *Client) action(name string, action string) (PeerStatus, error) {
+	var peer PeerStatus
+	err := client.call(http.MethodPost, "/peers/"+url.PathEscape(name)+"/"+action, nil, &peer)
+	return peer, err
+}
+
+/*
+Performs the whole handshake with the peer, nothing is sent if the session
+is already established
+*/
+func (client *Client) Connect(name string) (PeerStatus, error) {
+	return client.action(name, "connect")
+}
+
+func (client *Client) Hello(name string) (PeerStatus, error) {
+	return client.action(name, "hello")
+}
+
+func (client *Client) PublicKey(name string) (PeerStatus, error) {
+	return client.action(name, "publickey")
+}
+
+func (client *Client) Root(name string) (PeerStatus, error) {
+	return client.action(name, "root")
+}
+
+func (client *Client) NoOp(name string) (PeerStatus, error) {
+	return client.action(name, "noop")
+}
+
+/*
+Listing of a directory of the peer, or description of a file
+*/
+func (client *Client) List(name string, filePath string) (Listing, error) {
+	var listing Listing
+	err := client.call(http.MethodGet, "/peers/"+url.PathEscape(name)+"/tree/"+escapePath(filePath), nil, &listing)
+	return listing, err
+}
+
+/*
+Copies the content of a file of the peer to w
+*/
+func (client *Client) Cat(w io.Writer, name string, filePath string) error {
+	response, err := client.do(http.MethodGet, "/peers/"+url.PathEscape(name)+"/file/"+escapePath(filePath), nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+/*
+Writes a tree of the peer to w as a tar or zip archive
+*/
+func (client *Client) Archive(w io.Writer, name string, filePath string, format string) error {
+	response, err := client.do(http.MethodGet, "/peers/"+url.PathEscape(name)+"/archive/"+escapePath(filePath)+"?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+func (client *Client) Downloads() ([]Download, error) {
+	var list []Download
+	err := client.call(http.MethodGet, "/downloads", nil, &list)
+	return list, err
+}
+
+/*
+Starts downloading, the node keeps going after the call returns and the
+progress is followed with Download
+*/
+func (client *Client) StartDownload(request DownloadRequest) (Download, error) {
+	var download Download
+	err := client.call(http.MethodPost, "/downloads", request, &download)
+	return download, err
+}
+
+func (client *Client) Download(id int) (Download, error) {
+	var download Download
+	err := client.call(http.MethodGet, "/downloads/"+strconv.Itoa(id), nil, &download)
+	return download, err
+}
+
+func (client *Client) CancelDownload(id int) (Download, error) {
+	var download Download
+	err := client.call(http.MethodDelete, "/downloads/"+strconv.Itoa(id), nil, &download)
+	return download, err
+}
+
+func (client *Client) Exports() (Exports, error) {
+	var exports Exports
+	err := client.call(http.MethodGet, "/exports", nil, &exports)
+	return exports, err
+}
+
+/*
+Replaces the files exported by the node, paths are opened by the node so
+they should be absolute
+*/
+func (client *Client) SetExports(paths []string) (Exports, error) {
+	var exports Exports
+	err := client.call(http.MethodPut, "/exports", Exports{Paths: paths}, &exports)
+	return exports, err
+}
+
+func (client *Client) KnownPeers() ([]KnownPeer, error) {
+	var peers []KnownPeer
+	err := client.call(http.MethodGet, "/known-peers", nil, &peers)
+	return peers, err
+}
+
+/*
+Trusts the changed key of the peer instead of the one pinned before
+*/
+func (client *Client) AcceptKey(name string) (KnownPeer, error) {
+	var peer KnownPeer
+	err := client.call(http.MethodPost, "/known-peers/"+url.PathEscape(name)+"/accept", nil, &peer)
+	return peer, err
+}
+
+/*
+Health of the association of the node with the directory server
+*/
+func (client *Client) Server() (ServerStatus, error) {
+	var status ServerStatus
+	err := client.call(http.MethodGet, "/server", nil, &status)
+	return status, err
+}
+
+func (client *Client) Keys() (Keys, error) {
+	var keys Keys
+	err := client.call(http.MethodGet, "/keys", nil, &keys)
+	return keys, err
+}
+
+/*
+Makes the node replace its key pair and send the new key to the server
+*/
+func (client *Client) RotateKeys() (Keys, error) {
+	var keys Keys
+	err := client.call(http.MethodPost, "/keys/rotate", nil, &keys)
+	return keys, err
+}
@@ -0,0 +1,217 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/filestructure"
+	udptypes "protocoles-internet-2023/udp"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Downloads started through the API, they are kept after their end so that
+their result can still be asked for
+*/
+type downloads struct {
+	lock sync.Mutex
+	next int
+	list map[int]*download
+}
+
+type download struct {
+	status Download
+	cancel context.CancelFunc
+}
+
+/*
+Resolves the requested node then downloads it in the background, the
+returned status is the one of the download just started
+*/
+func (srv *Server) download(request DownloadRequest) (Download, error) {
+
+	node, dest, err := srv.resolveNode(request.Peer, request.Path)
+	if err != nil {
+		return Download{}, err
+	}
+
+	if request.Dest == "" {
+		request.Dest = node.Name
+		if request.Path == "" {
+			request.Dest = request.Peer + "-" + time.Now().Format("2006-01-02_15-04")
+		}
+	}
+	if !filepath.IsAbs(request.Dest) {
+		request.Dest = filepath.Join(srv.DownloadDir, request.Dest)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	logger.Info("download started", "peer", request.Peer, "path", request.Path, "dest", request.Dest)
+
+	srv.downloads.lock.Lock()
+	srv.downloads.next++
+	current := &download{
+		status: Download{
+			Id:      srv.downloads.next,
+			Peer:    request.Peer,
+			Path:    request.Path,
+			Dest:    request.Dest,
+			State:   Running,
+			Started: time.Now(),
+		},
+		cancel: cancel,
+	}
+	srv.downloads.list[current.status.Id] = current
+	status := current.status
+	srv.downloads.lock.Unlock()
+
+	go func() {
+		err := srv.writeTree(ctx, current, node, dest)
+
+		srv.downloads.lock.Lock()
+		defer srv.downloads.lock.Unlock()
+
+		switch {
+		case ctx.Err() != nil:
+			current.status.State = Canceled
+		case err != nil:
+			current.status.State = Failed
+			current.status.Error = err.Error()
+		default:
+			current.status.State = Done
+		}
+		cancel()
+
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("download failed", "id", current.status.Id, "peer", current.status.Peer, "path", current.status.Path, "err", err)
+		} else {
+			logger.Info("download "+current.status.State, "id", current.status.Id, "peer", current.status.Peer, "path", current.status.Path,
+				"files", current.status.Files, "bytes", current.status.Bytes)
+		}
+	}()
+
+	return status, nil
+}
+
+/*
+Files are written as their chunks arrive, the download stops at the first
+write after its cancellation
+*/
+func (srv *Server) writeTree(ctx context.Context, current *download, node udptypes.RemoteNode, dest *net.UDPAddr) error {
+	sched := srv.Node.Scheduler
+	root := current.status.Dest
+
+	return sched.WalkTree(node, root, dest, func(filePath string, node udptypes.RemoteNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if filePath != root {
+			if err := filestructure.CheckName(node.Name); err != nil {
+				return err
+			}
+		}
+
+		if node.Type == udptypes.DirectoryNode {
+			return os.MkdirAll(filepath.FromSlash(filePath), 0755)
+		}
+
+		file, err := os.Create(filepath.FromSlash(filePath))
+		if err != nil {
+			return err
+		}
+
+		_, err = sched.CopyFile(&progressWriter{ctx: ctx, downloads: &srv.downloads, current: current, w: file}, node, dest, 0, -1)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		srv.downloads.lock.Lock()
+		current.status.Files++
+		srv.downloads.lock.Unlock()
+
+		return file.Close()
+	})
+}
+
+// counts the bytes written and refuses to write once the download is canceled
+type progressWriter struct {
+	ctx       context.Context
+	downloads *downloads
+	current   *download
+	w         io.Writer
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	if err := pw.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := pw.w.Write(p)
+
+	pw.downloads.lock.Lock()
+	pw.current.status.Bytes += int64(n)
+	pw.downloads.lock.Unlock()
+
+	return n, err
+}
+
+func (d *downloads) get(id int) (Download, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	current, ok := d.list[id]
+	if !ok {
+		return Download{}, fmt.Errorf("%w: %d", ErrNoSuchDownload, id)
+	}
+
+	return current.status, nil
+}
+
+/*
+Asks the download to stop, it is marked as canceled once it did
+*/
+func (d *downloads) cancel(id int) (Download, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	current, ok := d.list[id]
+	if !ok {
+		return Download{}, fmt.Errorf("%w: %d", ErrNoSuchDownload, id)
+	}
+	current.cancel()
+
+	return current.status, nil
+}
+
+func (d *downloads) cancelAll() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, current := range d.list {
+		current.cancel()
+	}
+}
+
+// every download, oldest first
+func (d *downloads) snapshot() []Download {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	list := make([]Download, 0, len(d.list))
+	for _, current := range d.list {
+		list = append(list, current.status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+
+	return list
+}
@@ -0,0 +1,57 @@
+package control
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"protocoles-internet-2023/crypto"
+)
+
+var errNoKnownPeers = errors.New("keys are not pinned, known_peers is not set")
+
+func (srv *Server) serveKnownPeers(w http.ResponseWriter) {
+	knownPeers := srv.Node.Scheduler.KnownPeers
+	if knownPeers == nil {
+		writeJSON(w, []KnownPeer{})
+		return
+	}
+
+	writeJSON(w, knownPeers.List())
+}
+
+/*
+Trusts the key that replaced the pinned one, the next handshake with the
+peer goes through
+*/
+func (srv *Server) acceptKey(w http.ResponseWriter, name string) {
+	knownPeers := srv.Node.Scheduler.KnownPeers
+	if knownPeers == nil {
+		writeError(w, http.StatusBadRequest, errNoKnownPeers)
+		return
+	}
+
+	known, err := knownPeers.Accept(name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, known)
+}
+
+func (srv *Server) keys() Keys {
+	publicKey := crypto.FormatPublicKey(*srv.Node.Scheduler.PublicKey())
+	return Keys{
+		PublicKey:   hex.EncodeToString(publicKey),
+		Fingerprint: crypto.Fingerprint(publicKey),
+	}
+}
+
+func (srv *Server) rotateKeys(w http.ResponseWriter) {
+	if _, err := srv.Node.RotateKeys(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, srv.keys())
+}
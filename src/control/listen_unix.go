@@ -0,0 +1,19 @@
+//go:build unix
+
+package control
+
+import (
+	"net"
+	"syscall"
+)
+
+/*
+Listens on the Unix socket, readable by the user only from its creation
+The umask is the one of the whole process, it is only changed for the call
+*/
+func listenPrivate(socketPath string) (net.Listener, error) {
+	previous := syscall.Umask(0177)
+	defer syscall.Umask(previous)
+
+	return net.Listen("unix", socketPath)
+}
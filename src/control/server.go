@@ -0,0 +1,574 @@
+package control
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"protocoles-internet-2023/archive"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/logging"
+	"protocoles-internet-2023/node"
+	"protocoles-internet-2023/rest"
+	udptypes "protocoles-internet-2023/udp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var logger = logging.Logger("control")
+
+/*
+HTTP+JSON API driving a running node, served on a Unix socket so that only
+the local users allowed to open it can use it
+
+	GET    /peers                          peers registered on the server
+	GET    /peers/{name}                   what we know of a peer
+	POST   /peers/{name}/connect           whole handshake, unless the session is established
+	POST   /peers/{name}/hello             Hello only
+	POST   /peers/{name}/publickey         exchange of the public keys
+	POST   /peers/{name}/root              exchange of the roots
+	POST   /peers/{name}/noop              NoOp, nothing is expected in return
+	GET    /peers/{name}/tree/{path}       listing of a remote node
+	GET    /peers/{name}/file/{path}       content of a remote file
+	GET    /peers/{name}/archive/{path}    remote tree as a tar, or zip with ?format=zip
+	GET    /downloads                      every download since the start
+	POST   /downloads                      starts a download
+	GET    /downloads/{id}                 progress of a download
+	DELETE /downloads/{id}                 cancels a download
+	GET    /exports                        exported files
+	PUT    /exports                        replaces the exported files
+	GET    /keys                           our public key and its fingerprint
+	POST   /keys/rotate                    replaces our key pair and redoes the handshakes
+	GET    /known-peers                    keys of the peers pinned on first use
+	POST   /known-peers/{name}/accept      trusts the changed key of a peer
+
+Errors are answered as {"error": "..."}
+*/
+type Server struct {
+	Node        *node.Node
+	DownloadDir string
+	downloads   downloads
+	server      *http.Server
+}
+
+func NewServer(localNode *node.Node, downloadDir string) *Server {
+	srv := &Server{
+		Node:        localNode,
+		DownloadDir: downloadDir,
+		downloads: downloads{
+			list: make(map[int]*download),
+		},
+	}
+	srv.server = &http.Server{Handler: srv}
+
+	return srv
+}
+
+/*
+Opens the socket and serves the API on it until Shutdown
+A socket left by a node that did not stop cleanly is replaced, but not the
+one of a node still running
+*/
+func (srv *Server) Listen(socketPath string) error {
+
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return errors.New("a node is already running on " + socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return err
+		}
+	}
+
+	listener, err := listenPrivate(socketPath)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := srv.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("serving", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+/*
+Stops the running downloads and the server, the socket is removed
+*/
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.downloads.cancelAll()
+	return srv.server.Shutdown(ctx)
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	logger.Debug("request", "method", r.Method, "path", r.URL.Path)
+
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 4)
+
+	switch {
+	case parts[0] == "peers" && len(parts) == 1:
+		if allow(w, r, http.MethodGet) {
+			srv.servePeers(w, r)
+		}
+	case parts[0] == "peers" && len(parts) == 2:
+		if allow(w, r, http.MethodGet) {
+			status := srv.peerStatus(parts[1], nil)
+			srv.publishedStatus(r.Context(), &status)
+			writeJSON(w, status)
+		}
+	case parts[0] == "peers" && len(parts) == 3 && isView(parts[2]):
+		if allow(w, r, http.MethodGet) {
+			srv.serveTree(w, r, parts[1], parts[2], "")
+		}
+	case parts[0] == "peers" && len(parts) == 3:
+		if allow(w, r, http.MethodPost) {
+			srv.serveAction(w, parts[1], parts[2])
+		}
+	case parts[0] == "peers" && len(parts) == 4:
+		if allow(w, r, http.MethodGet) {
+			srv.serveTree(w, r, parts[1], parts[2], parts[3])
+		}
+	case parts[0] == "downloads" && len(parts) == 1:
+		if r.Method == http.MethodPost {
+			srv.startDownload(w, r)
+		} else if allow(w, r, http.MethodGet) {
+			writeJSON(w, srv.downloads.snapshot())
+		}
+	case parts[0] == "downloads" && len(parts) == 2:
+		srv.serveDownload(w, r, parts[1])
+	case parts[0] == "exports" && len(parts) == 1:
+		if r.Method == http.MethodPut {
+			srv.setExports(w, r)
+		} else if allow(w, r, http.MethodGet) {
+			writeJSON(w, srv.exports())
+		}
+	case parts[0] == "server" && len(parts) == 1:
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, srv.serverStatus())
+		}
+	case parts[0] == "keys" && len(parts) == 1:
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, srv.keys())
+		}
+	case parts[0] == "keys" && len(parts) == 2 && parts[1] == "rotate":
+		if allow(w, r, http.MethodPost) {
+			srv.rotateKeys(w)
+		}
+	case parts[0] == "known-peers" && len(parts) == 1:
+		if allow(w, r, http.MethodGet) {
+			srv.serveKnownPeers(w)
+		}
+	case parts[0] == "known-peers" && len(parts) == 3 && parts[2] == "accept":
+		if allow(w, r, http.MethodPost) {
+			srv.acceptKey(w, parts[1])
+		}
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.URL.Path))
+	}
+}
+
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logger.Debug("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+/*
+Unknown peers and paths are the client's fault, a changed key must be
+accepted first, anything else comes from the network or the peer
+*/
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownPeer) || errors.Is(err, ErrNoSuchDownload) || errors.Is(err, ErrNoPendingKey) ||
+		errors.Is(err, udptypes.ErrNotFound) || errors.Is(err, udptypes.ErrNoDatum) || errors.Is(err, rest.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrKeyChanged):
+		return http.StatusConflict
+	case errors.Is(err, udptypes.ErrNotDirectory) || errors.Is(err, udptypes.ErrNotFile):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func (srv *Server) resolvePeer(name string) (*net.UDPAddr, error) {
+	peers, err := srv.Node.Directory.GetPeersNames(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, peer := range peers {
+		if peer == name {
+			addrs, err := srv.Node.Directory.ResolvePeerAddresses(context.Background(), name)
+			if err != nil {
+				return nil, err
+			}
+			return srv.Node.Scheduler.Reach(name, addrs)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, name)
+}
+
+/*
+Resolves the peer, fetches its root and follows the path from there
+*/
+func (srv *Server) resolveNode(peerName string, filePath string) (udptypes.RemoteNode, *net.UDPAddr, error) {
+	dest, err := srv.resolvePeer(peerName)
+	if err != nil {
+		return udptypes.RemoteNode{}, nil, err
+	}
+
+	root, err := srv.Node.Scheduler.FetchRoot(dest)
+	if err != nil {
+		return udptypes.RemoteNode{}, nil, err
+	}
+
+	node, err := srv.Node.Scheduler.ResolvePath(root, filePath, dest)
+	if err != nil {
+		return udptypes.RemoteNode{}, nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	if node.Name == "" {
+		node.Name = peerName
+	}
+
+	return node, dest, nil
+}
+
+func (srv *Server) peerStatus(name string, dest *net.UDPAddr) PeerStatus {
+	status := PeerStatus{Name: name}
+
+	var peer *udptypes.PeerInfo
+	var ok bool
+	if dest != nil {
+		peer, ok = srv.Node.Scheduler.PeerByAddress(dest)
+	} else {
+		peer, ok = srv.Node.Scheduler.PeerByName(name)
+	}
+
+	if ok {
+		snapshot := peer.Snapshot()
+		status.Address = snapshot.Address.String()
+		status.Addresses = snapshot.Addresses
+		status.RTT = snapshot.LastRTT()
+		status.RTTHistory = snapshot.RTT
+		status.Failures = snapshot.Failures
+		status.State = snapshot.State.String()
+		status.LastSeen = snapshot.LastSeen.Format(time.RFC3339)
+		if !snapshot.Established.IsZero() {
+			status.Established = snapshot.Established.Format(time.RFC3339)
+		}
+		status.Encrypted = snapshot.Encrypted
+		status.Extensions = udptypes.ExtensionNames(snapshot.Negotiated)
+		if len(snapshot.PublicKey) != 0 {
+			status.PublicKey = hex.EncodeToString(snapshot.PublicKey)
+			status.KeyProven = snapshot.KeyProven
+		}
+		if snapshot.Root != [32]byte{} {
+			status.Root = hex.EncodeToString(snapshot.Root[:])
+		}
+	}
+
+	if knownPeers := srv.Node.Scheduler.KnownPeers; knownPeers != nil {
+		if known, ok := knownPeers.Get(name); ok {
+			status.Fingerprint = known.Fingerprint
+			status.KeyChanged = known.Pending != ""
+		}
+	}
+
+	return status
+}
+
+/*
+Adds what the REST server publishes about the peer to its status, to be
+compared with what it sent over UDP
+*/
+func (srv *Server) publishedStatus(ctx context.Context, status *PeerStatus) {
+	directory := srv.Node.Directory
+
+	key, err := directory.GetPeerKey(ctx, status.Name)
+	if err == nil {
+		status.PublishedKey = hex.EncodeToString(crypto.FormatPublicKey(*key))
+		status.KeyMismatch = status.PublicKey != "" && status.PublicKey != status.PublishedKey
+	} else if !errors.Is(err, rest.ErrNoKey) {
+		logger.Debug("could not fetch the published key", "peer", status.Name, "err", err)
+	}
+
+	root, err := directory.GetPeerRoot(ctx, status.Name)
+	if err == nil {
+		status.PublishedRoot = hex.EncodeToString(root[:])
+	} else if !errors.Is(err, rest.ErrNoRoot) {
+		logger.Debug("could not fetch the published root", "peer", status.Name, "err", err)
+	}
+}
+
+func (srv *Server) serverStatus() ServerStatus {
+	association := srv.Node.Association()
+	status := ServerStatus{
+		Healthy:    association.Healthy,
+		Registered: association.Registered,
+		Failures:   association.Failures,
+	}
+
+	if association.Server != nil {
+		status.Address = association.Server.String()
+	}
+	if association.LastError != nil {
+		status.Error = association.LastError.Error()
+	}
+	if !association.LastAttempt.IsZero() {
+		status.LastAttempt = association.LastAttempt.Format(time.RFC3339)
+		status.NextAttempt = association.NextAttempt.Format(time.RFC3339)
+	}
+	if !association.LastSuccess.IsZero() {
+		status.LastSuccess = association.LastSuccess.Format(time.RFC3339)
+	}
+
+	return status
+}
+
+func (srv *Server) servePeers(w http.ResponseWriter, r *http.Request) {
+	peers, err := srv.Node.Directory.GetPeersNames(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	statuses := make([]PeerStatus, len(peers))
+	for i, peer := range peers {
+		statuses[i] = srv.peerStatus(peer, nil)
+	}
+
+	writeJSON(w, statuses)
+}
+
+func (srv *Server) serveAction(w http.ResponseWriter, name string, action string) {
+	sched := srv.Node.Scheduler
+
+	dest, err := srv.resolvePeer(name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	switch action {
+	case "connect":
+		_, err = sched.Connect(dest)
+	case "hello":
+		_, err = sched.Hello(dest)
+	case "publickey":
+		_, err = sched.GetPublicKey(dest)
+	case "root":
+		_, err = sched.GetRoot(dest)
+	case "noop":
+		sched.SendNoOp(dest)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such action: "+action))
+		return
+	}
+
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, srv.peerStatus(name, dest))
+}
+
+func isView(view string) bool {
+	return view == "tree" || view == "file" || view == "archive"
+}
+
+func (srv *Server) serveTree(w http.ResponseWriter, r *http.Request, peerName string, view string, filePath string) {
+	if !isView(view) {
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.URL.Path))
+		return
+	}
+
+	node, dest, err := srv.resolveNode(peerName, filePath)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	sched := srv.Node.Scheduler
+
+	switch view {
+	case "tree":
+		listing := Listing{
+			Path: strings.Trim(filePath, "/"),
+			Hash: hex.EncodeToString(node.Hash[:]),
+			Type: FileEntry,
+		}
+		if node.Type == udptypes.DirectoryNode {
+			listing.Type = DirectoryEntry
+			listing.Entries = []Entry{}
+
+			for _, child := range node.Children {
+				childNode, err := sched.FetchNode(child.Hash, dest)
+				if err != nil {
+					writeError(w, errorStatus(err), err)
+					return
+				}
+
+				entry := Entry{
+					Name: child.Name,
+					Hash: hex.EncodeToString(child.Hash[:]),
+					Type: FileEntry,
+				}
+				if childNode.Type == udptypes.DirectoryNode {
+					entry.Type = DirectoryEntry
+				}
+				listing.Entries = append(listing.Entries, entry)
+			}
+		}
+
+		writeJSON(w, listing)
+		return
+
+	case "file":
+		if node.Type == udptypes.DirectoryNode {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", filePath, udptypes.ErrNotFile))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, err = sched.CopyFile(w, node, dest, 0, -1)
+
+	case "archive":
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = archive.Tar
+		}
+		if format != archive.Tar && format != archive.Zip {
+			writeError(w, http.StatusBadRequest, errors.New("unknown archive format: "+format))
+			return
+		}
+
+		name := peerName
+		if filePath = strings.Trim(filePath, "/"); filePath != "" {
+			name = path.Base(filePath)
+		}
+
+		w.Header().Set("Content-Type", "application/x-"+format)
+		err = archive.WriteArchive(w, format, sched, node, name, dest)
+	}
+
+	if err != nil {
+		// the status is already sent, the connection is cut so that the
+		// client does not take a truncated content for a complete one
+		logger.Warn("streaming interrupted", "peer", peerName, "path", filePath, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (srv *Server) startDownload(w http.ResponseWriter, r *http.Request) {
+	var request DownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("download request: "+err.Error()))
+		return
+	}
+
+	download, err := srv.download(request)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Location", "/downloads/"+strconv.Itoa(download.Id))
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, download)
+}
+
+func (srv *Server) serveDownload(w http.ResponseWriter, r *http.Request, idString string) {
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrNoSuchDownload, idString))
+		return
+	}
+
+	var download Download
+	switch r.Method {
+	case http.MethodGet:
+		download, err = srv.downloads.get(id)
+	case http.MethodDelete:
+		download, err = srv.downloads.cancel(id)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, download)
+}
+
+func (srv *Server) exports() Exports {
+	exported := srv.Node.Scheduler.Exports()
+
+	paths := srv.Node.ExportPaths()
+	if paths == nil {
+		paths = []string{}
+	}
+
+	return Exports{
+		Paths: paths,
+		Name:  exported.Name,
+		Root:  hex.EncodeToString(exported.Hash[:]),
+	}
+}
+
+func (srv *Server) setExports(w http.ResponseWriter, r *http.Request) {
+	var request Exports
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("exports: "+err.Error()))
+		return
+	}
+
+	for _, export := range request.Paths {
+		if _, err := os.Stat(export); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("export: "+err.Error()))
+			return
+		}
+	}
+
+	if err := srv.Node.SetExports(request.Paths); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, srv.exports())
+}
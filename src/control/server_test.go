@@ -0,0 +1,242 @@
+package control
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/directory"
+	"protocoles-internet-2023/node"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testConfig(t *testing.T, name string, endpoint string, exports string) config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Endpoint = endpoint
+	cfg.PeerName = name
+	cfg.Exports = []string{exports}
+	cfg.ListenAddress = "127.0.0.1:0"
+	cfg.KeyStore = filepath.Join(t.TempDir(), "keys.db")
+	cfg.KnownPeers = ""
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func startNode(t *testing.T, cfg config.Config) *node.Node {
+	t.Helper()
+	started, err := node.NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started.Start()
+	t.Cleanup(started.Shutdown)
+	return started
+}
+
+/*
+Directory server with alice and bob registered, bob exporting a small tree
+Returns the socket of the control API of alice and a client of it
+*/
+func newTestNetwork(t *testing.T) (string, *Client, *Server) {
+	t.Helper()
+
+	srv, err := directory.NewServer("jch.irif.fr", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(func() { srv.Close() })
+	rest := httptest.NewServer(srv)
+	t.Cleanup(rest.Close)
+
+	exports := t.TempDir()
+	if err = os.Mkdir(filepath.Join(exports, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"hello.txt": "hello\n", "docs/notes.txt": "notes\n"} {
+		if err = os.WriteFile(filepath.Join(exports, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	startNode(t, testConfig(t, "bob", rest.URL, exports))
+
+	alice := startNode(t, testConfig(t, "alice", rest.URL, t.TempDir()))
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	server := NewServer(alice, t.TempDir())
+	if err = server.Listen(socket); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	client, err := Dial(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return socket, client, server
+}
+
+func TestListen(t *testing.T) {
+	socket, _, server := newTestNetwork(t)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			t.Errorf("socket readable by others: %s", info.Mode())
+		}
+	}
+
+	if err := NewServer(server.Node, server.DownloadDir).Listen(socket); err == nil {
+		t.Error("second node listening on the socket of a running one")
+	}
+}
+
+func TestPeersAndFiles(t *testing.T) {
+	_, client, _ := newTestNetwork(t)
+
+	peers, err := client.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(peers, func(peer PeerStatus) bool { return peer.Name == "bob" }) {
+		t.Fatalf("bob not among %v", peers)
+	}
+
+	bob, err := client.Connect("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.Address == "" || bob.Root == "" || !bob.Encrypted {
+		t.Errorf("after connecting: %+v", bob)
+	}
+
+	listing, err := client.List("bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name+" "+entry.Type)
+	}
+	if listing.Hash != bob.Root || !slices.Equal(names, []string{"docs directory", "hello.txt file"}) {
+		t.Errorf("root %s with %v", listing.Hash, names)
+	}
+
+	var content bytes.Buffer
+	if err = client.Cat(&content, "bob", "docs/notes.txt"); err != nil || content.String() != "notes\n" {
+		t.Errorf("cat: got %q, %v", content.String(), err)
+	}
+
+	content.Reset()
+	if err = client.Archive(&content, "bob", "docs", "tar"); err != nil {
+		t.Fatal(err)
+	}
+	var archived []string
+	for tr := tar.NewReader(&content); ; {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		archived = append(archived, header.Name)
+	}
+	if !slices.Equal(archived, []string{"docs/", "docs/notes.txt"}) {
+		t.Errorf("archive holds %v", archived)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, client, _ := newTestNetwork(t)
+
+	if _, err := client.List("carol", ""); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("unknown peer: got %v", err)
+	}
+	if err := client.Cat(io.Discard, "bob", "missing.txt"); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("missing file: got %v", err)
+	}
+	if err := client.Cat(io.Discard, "bob", "docs"); err == nil {
+		t.Error("cat of a directory accepted")
+	}
+	if _, err := client.Download(42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown download: got %v", err)
+	}
+
+	var apiError *APIError
+	if _, err := client.do(http.MethodDelete, "/peers", nil); !errors.As(err, &apiError) || apiError.Status != http.StatusMethodNotAllowed {
+		t.Errorf("wrong method: got %v", err)
+	}
+	if _, err := client.do(http.MethodGet, "/nothing", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown endpoint: got %v", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	_, client, server := newTestNetwork(t)
+
+	download, err := client.StartDownload(DownloadRequest{Peer: "bob", Path: "docs", Dest: "from-bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if download.Dest != filepath.Join(server.DownloadDir, "from-bob") {
+		t.Errorf("relative destination %s not in the download directory", download.Dest)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for download.State == Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if download, err = client.Download(download.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if download.State != Done || download.Files != 1 || download.Bytes != int64(len("notes\n")) {
+		t.Fatalf("download ended with %+v", download)
+	}
+
+	content, err := os.ReadFile(filepath.Join(download.Dest, "notes.txt"))
+	if err != nil || string(content) != "notes\n" {
+		t.Errorf("downloaded %q, %v", content, err)
+	}
+	if downloads, err := client.Downloads(); err != nil || len(downloads) != 1 {
+		t.Errorf("downloads: %v, %v", downloads, err)
+	}
+}
+
+func TestSetExports(t *testing.T) {
+	_, client, _ := newTestNetwork(t)
+
+	before, err := client.Exports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.SetExports([]string{filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("missing export accepted")
+	}
+
+	export := t.TempDir()
+	if err = os.WriteFile(filepath.Join(export, "new.txt"), []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := client.SetExports([]string{export})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(after.Paths, []string{export}) || after.Root == before.Root {
+		t.Errorf("exports %v with root %s, was %s", after.Paths, after.Root, before.Root)
+	}
+}
@@ -0,0 +1,138 @@
+package control
+
+import (
+	"errors"
+	"protocoles-internet-2023/crypto"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrUnknownPeer = errors.New("no such peer on the server")
+var ErrNoSuchDownload = errors.New("no such download")
+
+// the key sent by the peer is not the pinned one, see KnownPeers
+var ErrKeyChanged = crypto.ErrKeyChanged
+var ErrNoPendingKey = crypto.ErrNoPendingKey
+
+// state of a download
+const (
+	Running  = "running"
+	Done     = "done"
+	Failed   = "failed"
+	Canceled = "canceled"
+)
+
+// type of an entry of a listing
+const (
+	FileEntry      = "file"
+	DirectoryEntry = "directory"
+)
+
+/*
+What we know of a peer, keys and hashes are hexadecimal
+Address and the fields after it are only set once the peer answered a Hello
+*/
+type PeerStatus struct {
+	Name      string `json:"name"`
+	Address   string `json:"address,omitempty"` // where the peer was last authenticated
+	PublicKey string `json:"public_key,omitempty"`
+	KeyProven bool   `json:"key_proven,omitempty"` // the peer signed with its key or encrypted with it
+	Root      string `json:"root,omitempty"`
+	RTT       int64  `json:"rtt_ms,omitempty"`
+	State     string `json:"state,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"` // bodies exchanged with the peer are encrypted
+
+	Extensions []string `json:"extensions,omitempty"` // negotiated with the peer
+	Addresses  []string `json:"addresses,omitempty"`  // every address the peer was seen at
+
+	LastSeen    string  `json:"last_seen,omitempty"`      // last message accepted from the peer
+	Established string  `json:"established,omitempty"`    // end of the last handshake
+	RTTHistory  []int64 `json:"rtt_history_ms,omitempty"` // oldest first
+	Failures    int     `json:"failures,omitempty"`       // requests left without reply since the last answered one
+
+	Fingerprint string `json:"fingerprint,omitempty"` // of the pinned key
+	KeyChanged  bool   `json:"key_changed,omitempty"` // the peer sent another key, which must be accepted
+
+	// what the peer published on the REST server, only for GET /peers/{name}
+	PublishedKey  string `json:"published_key,omitempty"`
+	PublishedRoot string `json:"published_root,omitempty"`
+	KeyMismatch   bool   `json:"key_mismatch,omitempty"` // the key sent over UDP is not the published one
+}
+
+type Entry struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	Type string `json:"type"`
+}
+
+/*
+A node of a remote tree, Entries is only set for directories
+*/
+type Listing struct {
+	Path    string  `json:"path"`
+	Hash    string  `json:"hash"`
+	Type    string  `json:"type"`
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+/*
+Dest is relative to the download directory of the node, the whole tree of
+the peer is downloaded when Path is empty
+*/
+type DownloadRequest struct {
+	Peer string `json:"peer"`
+	Path string `json:"path"`
+	Dest string `json:"dest,omitempty"`
+}
+
+type Download struct {
+	Id      int       `json:"id"`
+	Peer    string    `json:"peer"`
+	Path    string    `json:"path"`
+	Dest    string    `json:"dest"`
+	State   string    `json:"state"`
+	Files   int       `json:"files"`
+	Bytes   int64     `json:"bytes"`
+	Error   string    `json:"error,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+/*
+Paths are the directories or archives given to the node, Root is the hash
+of the tree built from them
+*/
+type Exports struct {
+	Paths []string `json:"paths"`
+	Name  string   `json:"name,omitempty"`
+	Root  string   `json:"root,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+/*
+Health of the association with the directory server, times are RFC 3339
+and only set once an attempt was made
+*/
+type ServerStatus struct {
+	Address     string `json:"address,omitempty"` // where the server answered
+	Healthy     bool   `json:"healthy"`
+	Registered  bool   `json:"registered"`         // our name, key and root are published by the REST server
+	Failures    int    `json:"failures,omitempty"` // attempts failed since the last success
+	Error       string `json:"error,omitempty"`    // of the last attempt
+	LastAttempt string `json:"last_attempt,omitempty"`
+	LastSuccess string `json:"last_success,omitempty"`
+	NextAttempt string `json:"next_attempt,omitempty"`
+}
+
+/*
+Our public key, hexadecimal, in the format of the protocol
+*/
+type Keys struct {
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// key of a peer pinned on first use, the pending key is the changed one
+type KnownPeer = crypto.KnownPeer
@@ -9,6 +9,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 )
 
 func EncodeToString(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) (string, string) {
@@ -21,15 +22,35 @@ func EncodeToString(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) (s
 	return string(pemEncoded), string(pemEncodedPub)
 }
 
-func DecodeFromString(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey) {
-	block, _ := pem.Decode([]byte(pemEncoded))
-	x509Encoded := block.Bytes
-	privateKey, _ := x509.ParseECPrivateKey(x509Encoded)
-
-	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
-	x509EncodedPub := blockPub.Bytes
-	genericPublicKey, _ := x509.ParsePKIXPublicKey(x509EncodedPub)
-	publicKey := genericPublicKey.(*ecdsa.PublicKey)
+/*
+Parses a PEM private key, SEC 1 as we write it or PKCS #8, which must be
+a P-256 ECDSA key
+*/
+func DecodePrivateKey(pemEncoded []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemEncoded)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err == nil {
+		return checkCurve(privateKey)
+	}
+
+	generic, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("not an ECDSA private key: " + err.Error())
+	}
+	privateKey, ok := generic.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA private key")
+	}
+	return checkCurve(privateKey)
+}
 
-	return privateKey, publicKey
+func checkCurve(privateKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, error) {
+	if privateKey.Curve.Params().Name != "P-256" {
+		return nil, errors.New("the key must be on P-256, not " + privateKey.Curve.Params().Name)
+	}
+	return privateKey, nil
 }
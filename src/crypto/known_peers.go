@@ -0,0 +1,322 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrKeyChanged = errors.New("public key of the peer changed")
+var ErrNoPendingKey = errors.New("no changed key to accept for this peer")
+
+// names pinned at most, the keys of the other peers are not pinned
+const MaxKnownPeers = 4096
+
+// a change is saved that long after it was made, with the following ones
+const knownPeersSaveDelay = 5 * time.Second
+
+/*
+Key of a peer trusted the first time we saw it
+A different key is only trusted once accepted, until then it stays in
+Pending
+*/
+type KnownPeer struct {
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	Key         string    `json:"key"` // hexadecimal
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Pending     string    `json:"pending,omitempty"` // changed key, hexadecimal
+
+	PendingFingerprint string `json:"pending_fingerprint,omitempty"`
+}
+
+/*
+The key a peer sent differs from the pinned one
+*/
+type KeyChangedError struct {
+	Name     string
+	Known    string // fingerprints
+	Received string
+}
+
+func (err *KeyChangedError) Error() string {
+	return ErrKeyChanged.Error() + ": " + err.Name + " was " + err.Known + ", now " + err.Received
+}
+
+func (err *KeyChangedError) Is(target error) bool {
+	return target == ErrKeyChanged
+}
+
+/*
+Trust on first use store of the keys of the peers, saved as JSON
+Changes are saved in the background, see Flush
+*/
+type KnownPeers struct {
+	path  string
+	lock  sync.Mutex
+	peers map[string]*KnownPeer
+
+	dirty    bool       // changed since saved
+	saving   bool       // a save is scheduled
+	saveLock sync.Mutex // one save at a time
+}
+
+/*
+SHA-256 of a key, in hexadecimal, an empty key has no fingerprint
+*/
+func Fingerprint(key []byte) string {
+	if len(key) == 0 {
+		return "none"
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+Loads the store, a missing file is an empty store created on the first key
+*/
+func OpenKnownPeers(path string) (*KnownPeers, error) {
+	known := KnownPeers{
+		path:  path,
+		peers: make(map[string]*KnownPeer),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &known, nil
+	} else if err != nil {
+		return nil, errors.New("reading known peers: " + err.Error())
+	}
+
+	var peers []*KnownPeer
+	if err = json.Unmarshal(data, &peers); err != nil {
+		return nil, errors.New("known peers file " + path + " is corrupt: " + err.Error())
+	}
+	for _, peer := range peers {
+		known.peers[peer.Name] = peer
+	}
+
+	return &known, nil
+}
+
+/*
+Compares the key sent by a peer with the pinned one, without recording
+anything: the peer did not prove it holds the key yet
+A different key, or none after a key, is a KeyChangedError
+*/
+func (known *KnownPeers) Verify(name string, key []byte) error {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	peer, ok := known.peers[name]
+	if !ok || peer.Key == hex.EncodeToString(key) {
+		return nil
+	}
+	return &KeyChangedError{
+		Name:     name,
+		Known:    peer.Fingerprint,
+		Received: Fingerprint(key),
+	}
+}
+
+/*
+Records the key a peer proved it holds: the first key of a name is pinned,
+unless MaxKnownPeers are, the next ones must be the same
+A different key is kept pending and a KeyChangedError is returned. A peer
+that never had a key is not pinned
+*/
+func (known *KnownPeers) Record(name string, key []byte) error {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	now := time.Now()
+	peer, ok := known.peers[name]
+	if !ok {
+		if len(key) == 0 {
+			return nil
+		}
+		if len(known.peers) >= MaxKnownPeers {
+			logger.Warn("too many known peers, key not pinned", "peer", name, "fingerprint", Fingerprint(key))
+			return nil
+		}
+		known.peers[name] = &KnownPeer{
+			Name:        name,
+			Fingerprint: Fingerprint(key),
+			Key:         hex.EncodeToString(key),
+			FirstSeen:   now,
+			LastSeen:    now,
+		}
+		logger.Info("new peer key pinned", "peer", name, "fingerprint", Fingerprint(key))
+		known.changed()
+		return nil
+	}
+
+	if peer.Key == hex.EncodeToString(key) {
+		peer.LastSeen = now
+		peer.Pending = ""
+		peer.PendingFingerprint = ""
+		known.changed()
+		return nil
+	}
+
+	peer.Pending = hex.EncodeToString(key)
+	peer.PendingFingerprint = Fingerprint(key)
+	known.changed()
+	return &KeyChangedError{
+		Name:     name,
+		Known:    peer.Fingerprint,
+		Received: Fingerprint(key),
+	}
+}
+
+/*
+Trusts the changed key of a peer instead of the pinned one
+*/
+func (known *KnownPeers) Accept(name string) (KnownPeer, error) {
+	accepted, err := known.accept(name)
+	if err != nil {
+		return KnownPeer{}, err
+	}
+	return accepted, known.Flush()
+}
+
+func (known *KnownPeers) accept(name string) (KnownPeer, error) {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	peer, ok := known.peers[name]
+	if !ok || peer.Pending == "" {
+		return KnownPeer{}, ErrNoPendingKey
+	}
+
+	key, err := hex.DecodeString(peer.Pending)
+	if err != nil {
+		return KnownPeer{}, err
+	}
+	previous := peer.Fingerprint
+
+	peer.Key = peer.Pending
+	peer.Fingerprint = Fingerprint(key)
+	peer.Pending = ""
+	peer.PendingFingerprint = ""
+	peer.FirstSeen = time.Now()
+	peer.LastSeen = peer.FirstSeen
+	known.dirty = true
+
+	logger.Warn("changed peer key accepted", "peer", name, "previous", previous, "fingerprint", peer.Fingerprint)
+
+	return *peer, nil
+}
+
+/*
+Every known peer, sorted by name
+*/
+func (known *KnownPeers) List() []KnownPeer {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	list := make([]KnownPeer, 0, len(known.peers))
+	for _, peer := range known.peers {
+		list = append(list, *peer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+func (known *KnownPeers) Get(name string) (KnownPeer, bool) {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	peer, ok := known.peers[name]
+	if !ok {
+		return KnownPeer{}, false
+	}
+	return *peer, true
+}
+
+// the lock is held, the store is saved a little later
+func (known *KnownPeers) changed() {
+	known.dirty = true
+	if known.saving {
+		return
+	}
+	known.saving = true
+	time.AfterFunc(knownPeersSaveDelay, func() {
+		if err := known.Flush(); err != nil {
+			logger.Warn("saving known peers", "err", err)
+		}
+	})
+}
+
+/*
+Saves the changes not saved yet, e.g. before stopping
+*/
+func (known *KnownPeers) Flush() error {
+	known.saveLock.Lock()
+	defer known.saveLock.Unlock()
+
+	known.lock.Lock()
+	known.saving = false
+	if !known.dirty {
+		known.lock.Unlock()
+		return nil
+	}
+	known.dirty = false
+	data, err := known.marshal()
+	known.lock.Unlock()
+
+	if err == nil {
+		err = known.write(data)
+	}
+	if err != nil {
+		known.lock.Lock()
+		known.dirty = true
+		known.lock.Unlock()
+	}
+	return err
+}
+
+// the lock is held
+func (known *KnownPeers) marshal() ([]byte, error) {
+	list := make([]*KnownPeer, 0, len(known.peers))
+	for _, peer := range known.peers {
+		list = append(list, peer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return json.MarshalIndent(list, "", "  ")
+}
+
+// written to a temporary file then renamed, the store is never left half written
+func (known *KnownPeers) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(known.path), filepath.Base(known.path)+".*")
+	if err != nil {
+		return errors.New("saving known peers: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.New("saving known peers: " + err.Error())
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.New("saving known peers: " + err.Error())
+	}
+
+	if err = os.Rename(tmp.Name(), known.path); err != nil {
+		return errors.New("saving known peers: " + err.Error())
+	}
+	return nil
+}
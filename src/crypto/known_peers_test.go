@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newKnownPeers(t *testing.T) (*KnownPeers, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_peers.json")
+	known, err := OpenKnownPeers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return known, path
+}
+
+func publicKey(t *testing.T) []byte {
+	t.Helper()
+	_, key, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return FormatPublicKey(*key)
+}
+
+func TestVerifyPinsNothing(t *testing.T) {
+	known, path := newKnownPeers(t)
+
+	if err := known.Verify("alice", publicKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(known.List()) != 0 {
+		t.Fatal("key pinned before the peer proved it")
+	}
+	if err := known.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("store written without change: %v", err)
+	}
+}
+
+func TestRecordChangedKey(t *testing.T) {
+	known, path := newKnownPeers(t)
+	alice, other := publicKey(t), publicKey(t)
+
+	if err := known.Record("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := known.Verify("alice", alice); err != nil {
+		t.Fatalf("pinned key refused: %v", err)
+	}
+	if err := known.Verify("alice", other); !errors.Is(err, ErrKeyChanged) {
+		t.Fatalf("other key: got %v, want ErrKeyChanged", err)
+	}
+	if _, err := known.Accept("alice"); !errors.Is(err, ErrNoPendingKey) {
+		t.Fatalf("key not proven accepted: %v", err)
+	}
+
+	if err := known.Record("alice", other); !errors.Is(err, ErrKeyChanged) {
+		t.Fatalf("other key proven: got %v, want ErrKeyChanged", err)
+	}
+	accepted, err := known.Accept("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Fingerprint != Fingerprint(other) {
+		t.Fatal("accepted another key than the pending one")
+	}
+
+	// saved when accepted
+	reopened, err := OpenKnownPeers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer, ok := reopened.Get("alice"); !ok || peer.Fingerprint != Fingerprint(other) {
+		t.Fatal("accepted key not saved")
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	known, _ := newKnownPeers(t)
+	key := publicKey(t)
+
+	for i := 0; i < MaxKnownPeers; i++ {
+		if err := known.Record(fmt.Sprint("peer", i), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := known.Record("alice", publicKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := known.Get("alice"); ok {
+		t.Fatal("key pinned beyond MaxKnownPeers")
+	}
+	if err := known.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
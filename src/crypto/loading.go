@@ -2,54 +2,231 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
-	"fmt"
 	"github.com/rapidloop/skv"
+	"protocoles-internet-2023/logging"
 )
 
-func LoadFromDisk(filepath string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
-	var privateKey *ecdsa.PrivateKey
-	var publicKey *ecdsa.PublicKey
+var logger = logging.Logger("crypto")
 
-	//Private and Public key Generation or retrieval from bank
-	keysFile, err := skv.Open(filepath)
-	defer keysFile.Close()
+var ErrNoStoredKey = errors.New("no key in the store")
+var ErrPassphraseRequired = errors.New("the private key is encrypted, a passphrase is required")
 
+// entries of the store
+const (
+	privateEntry   = "private"   // PEM in clear, without passphrase
+	encryptedEntry = "encrypted" // sealedKey as JSON, with a passphrase
+	publicEntry    = "public"    // PEM
+)
+
+/*
+skv (bolt) database holding our key pair, the private key is encrypted when
+a passphrase is given
+*/
+type KeyStore struct {
+	Path       string
+	Passphrase string
+}
+
+func (store KeyStore) open() (*skv.KVStore, error) {
+	db, err := skv.Open(store.Path)
 	if err != nil {
-		return &ecdsa.PrivateKey{}, &ecdsa.PublicKey{}, errors.New("could open file: " + err.Error())
+		return nil, errors.New("opening key store " + store.Path + ": " + err.Error())
 	}
+	return db, nil
+}
 
-	var privateKeyString string
-	var publicKeyString string
+func (store KeyStore) corrupt(err error) error {
+	return errors.New("corrupt key store " + store.Path + ": " + err.Error())
+}
 
-	err = keysFile.Get("private", &privateKeyString)
+/*
+Reads our private key, ErrNoStoredKey if the store has none
+A store that cannot be read is an error, never a reason to make a new key
+A key stored in clear is encrypted as soon as a passphrase is given
+*/
+func (store KeyStore) Load() (*ecdsa.PrivateKey, error) {
+	db, err := store.open()
 	if err != nil {
-		fmt.Println("could not get private key: ", err.Error(), "\nGenerating new pair...")
+		return nil, err
+	}
+	defer db.Close()
+
+	var sealedJSON string
+	err = db.Get(encryptedEntry, &sealedJSON)
+	if err == nil {
+		if store.Passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
 
-		privateKey, publicKey, err = GenerateKeys()
+		var sealed sealedKey
+		if err = json.Unmarshal([]byte(sealedJSON), &sealed); err != nil {
+			return nil, store.corrupt(err)
+		}
+		privatePEM, err := sealed.open(store.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := DecodePrivateKey(privatePEM)
 		if err != nil {
-			return &ecdsa.PrivateKey{}, &ecdsa.PublicKey{}, errors.New("could not generate keys: " + err.Error())
+			return nil, store.corrupt(err)
 		}
+		return privateKey, nil
+	} else if !errors.Is(err, skv.ErrNotFound) {
+		return nil, store.corrupt(err)
+	}
 
-		privateKeyString, publicKeyString = EncodeToString(privateKey, publicKey)
+	var privatePEM string
+	err = db.Get(privateEntry, &privatePEM)
+	if errors.Is(err, skv.ErrNotFound) {
+		return nil, ErrNoStoredKey
+	} else if err != nil {
+		return nil, store.corrupt(err)
+	}
 
-		err := keysFile.Put("private", privateKeyString)
-		if err != nil {
-			return &ecdsa.PrivateKey{}, &ecdsa.PublicKey{}, errors.New("could not store private key: " + err.Error())
+	privateKey, err := DecodePrivateKey([]byte(privatePEM))
+	if err != nil {
+		return nil, store.corrupt(err)
+	}
+
+	if store.Passphrase == "" {
+		logger.Warn("private key stored in clear, set a passphrase to encrypt it", "store", store.Path)
+	} else {
+		if err = store.save(db, privateKey); err != nil {
+			return nil, errors.New("encrypting the private key: " + err.Error())
 		}
+		logger.Info("private key encrypted with the passphrase", "store", store.Path)
+	}
+
+	return privateKey, nil
+}
+
+/*
+Our public key, readable without the passphrase
+*/
+func (store KeyStore) PublicKey() (*ecdsa.PublicKey, error) {
+	db, err := store.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var publicPEM string
+	err = db.Get(publicEntry, &publicPEM)
+	if errors.Is(err, skv.ErrNotFound) {
+		return nil, ErrNoStoredKey
+	} else if err != nil {
+		return nil, store.corrupt(err)
+	}
+
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return nil, store.corrupt(errors.New("no PEM block for the public key"))
+	}
+	generic, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, store.corrupt(err)
+	}
+	publicKey, ok := generic.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, store.corrupt(errors.New("not an ECDSA public key"))
+	}
 
-		err = keysFile.Put("public", publicKeyString)
+	return publicKey, nil
+}
+
+/*
+Replaces the key pair of the store
+*/
+func (store KeyStore) Save(privateKey *ecdsa.PrivateKey) error {
+	db, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return store.save(db, privateKey)
+}
+
+func (store KeyStore) save(db *skv.KVStore, privateKey *ecdsa.PrivateKey) error {
+	privatePEM, publicPEM := EncodeToString(privateKey, &privateKey.PublicKey)
+
+	if store.Passphrase == "" {
+		// an encrypted key is never replaced by one in clear
+		var sealedJSON string
+		if err := db.Get(encryptedEntry, &sealedJSON); err == nil {
+			return ErrPassphraseRequired
+		}
+		if err := db.Put(privateEntry, privatePEM); err != nil {
+			return errors.New("could not store private key: " + err.Error())
+		}
+	} else {
+		sealed, err := seal([]byte(privatePEM), store.Passphrase)
+		if err != nil {
+			return err
+		}
+		sealedJSON, err := json.Marshal(sealed)
 		if err != nil {
-			return &ecdsa.PrivateKey{}, &ecdsa.PublicKey{}, errors.New("could not store public key: " + err.Error())
+			return err
 		}
+		if err = db.Put(encryptedEntry, string(sealedJSON)); err != nil {
+			return errors.New("could not store private key: " + err.Error())
+		}
+		if err = db.Delete(privateEntry); err != nil && !errors.Is(err, skv.ErrNotFound) {
+			return errors.New("could not remove the private key in clear: " + err.Error())
+		}
+	}
+
+	if err := db.Put(publicEntry, publicPEM); err != nil {
+		return errors.New("could not store public key: " + err.Error())
 	}
 
-	err = keysFile.Get("public", &publicKeyString)
+	return nil
+}
+
+/*
+Replaces our key pair by a new one, which is returned
+*/
+func (store KeyStore) Rotate() (*ecdsa.PrivateKey, error) {
+	privateKey, _, err := GenerateKeys()
 	if err != nil {
-		return &ecdsa.PrivateKey{}, &ecdsa.PublicKey{}, errors.New("could not get public key: " + err.Error())
+		return nil, errors.New("could not generate keys: " + err.Error())
+	}
+
+	if err = store.Save(privateKey); err != nil {
+		return nil, err
 	}
 
-	privateKey, publicKey = DecodeFromString(privateKeyString, publicKeyString)
+	logger.Warn("key pair rotated", "store", store.Path, "fingerprint", Fingerprint(FormatPublicKey(privateKey.PublicKey)))
+
+	return privateKey, nil
+}
+
+/*
+Loads our key pair, a new one is only generated when the store has none
+*/
+func LoadFromDisk(filepath string, passphrase string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	store := KeyStore{
+		Path:       filepath,
+		Passphrase: passphrase,
+	}
+
+	privateKey, err := store.Load()
+	if errors.Is(err, ErrNoStoredKey) {
+		logger.Warn("no private key in store, generating a new pair", "store", filepath)
+
+		privateKey, _, err = GenerateKeys()
+		if err != nil {
+			return nil, nil, errors.New("could not generate keys: " + err.Error())
+		}
+		err = store.Save(privateKey)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return privateKey, publicKey, nil
+	return privateKey, &privateKey.PublicKey, nil
 }
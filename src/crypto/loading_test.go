@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"errors"
+	"github.com/rapidloop/skv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStore(t *testing.T, passphrase string) KeyStore {
+	t.Helper()
+
+	store := KeyStore{Path: filepath.Join(t.TempDir(), "keys.db"), Passphrase: passphrase}
+	privateKey, _, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestKeyStorePassphrase(t *testing.T) {
+	store := newStore(t, "correct horse")
+
+	privateKey, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := store.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !privateKey.PublicKey.Equal(publicKey) {
+		t.Fatal("public key of the store does not match the private key")
+	}
+
+	wrong := KeyStore{Path: store.Path, Passphrase: "battery staple"}
+	if _, err := wrong.Load(); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("wrong passphrase: got %v, want ErrWrongPassphrase", err)
+	}
+
+	missing := KeyStore{Path: store.Path}
+	if _, err := missing.Load(); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("no passphrase: got %v, want ErrPassphraseRequired", err)
+	}
+	// an encrypted key is never replaced by one in clear
+	if err := missing.Save(privateKey); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("saving without passphrase: got %v, want ErrPassphraseRequired", err)
+	}
+}
+
+func TestKeyStoreEncryptsKeyInClear(t *testing.T) {
+	store := newStore(t, "")
+	privateKey, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Passphrase = "correct horse"
+	if _, err = store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	inClear := KeyStore{Path: store.Path}
+	if _, err := inClear.Load(); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("key still readable without passphrase: %v", err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(privateKey) {
+		t.Fatal("key changed when encrypted")
+	}
+}
+
+func TestKeyStoreCorrupt(t *testing.T) {
+	t.Run("sealed key", func(t *testing.T) {
+		store := newStore(t, "correct horse")
+
+		db, err := skv.Open(store.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Put(encryptedEntry, "{not json")
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.Load(); err == nil {
+			t.Fatal("corrupt store loaded")
+		}
+	})
+
+	t.Run("not a store", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.db")
+		if err := os.WriteFile(path, []byte("not a key store"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		// the store is refused, no new key is made in its place
+		if _, _, err := LoadFromDisk(path, ""); err == nil {
+			t.Fatal("corrupt store replaced")
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || string(content) != "not a key store" {
+			t.Fatal("corrupt store overwritten")
+		}
+	})
+}
+
+func TestLoadFromDiskGeneratesOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+
+	first, _, err := LoadFromDisk(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := LoadFromDisk(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(second) {
+		t.Fatal("a new key was generated although the store has one")
+	}
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase, or corrupt key store")
+
+// PBKDF2 iterations for new keys, the count is stored with every sealed key
+const kdfIterations = 600000
+
+// beyond, opening a key store would take minutes: it is refused as corrupt
+const maxKDFIterations = 10 * kdfIterations
+
+/*
+Private key encrypted with AES-256-GCM, under a key derived from a
+passphrase with PBKDF2-HMAC-SHA256
+*/
+type sealedKey struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+/*
+PBKDF2 (RFC 8018) with HMAC-SHA256
+*/
+func pbkdf2(passphrase []byte, salt []byte, iterations int, length int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+
+	var derived []byte
+	for block := uint32(1); len(derived) < length; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		derived = append(derived, t...)
+	}
+
+	return derived[:length]
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(data []byte, passphrase string) (sealedKey, error) {
+	sealed := sealedKey{
+		KDF:        "pbkdf2-sha256",
+		Iterations: kdfIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return sealedKey{}, err
+	}
+
+	aead, err := newAEAD(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return sealedKey{}, err
+	}
+
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(sealed.Nonce); err != nil {
+		return sealedKey{}, err
+	}
+	sealed.Data = aead.Seal(nil, sealed.Nonce, data, nil)
+
+	return sealed, nil
+}
+
+func (sealed sealedKey) open(passphrase string) ([]byte, error) {
+	if sealed.KDF != "pbkdf2-sha256" {
+		return nil, errors.New("unknown key derivation: " + sealed.KDF)
+	}
+	if sealed.Iterations < 1 || sealed.Iterations > maxKDFIterations {
+		return nil, errors.New("invalid key derivation iterations: " + strconv.Itoa(sealed.Iterations))
+	}
+
+	aead, err := newAEAD(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+
+	data, err := aead.Open(nil, sealed.Nonce, sealed.Data, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return data, nil
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// test vectors of RFC 7914, section 11
+func TestPBKDF2(t *testing.T) {
+	vectors := []struct {
+		passphrase, salt string
+		iterations       int
+		derived          string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, vector := range vectors {
+		expected, _ := hex.DecodeString(vector.derived)
+		derived := pbkdf2([]byte(vector.passphrase), []byte(vector.salt), vector.iterations, len(expected))
+		if !bytes.Equal(derived, expected) {
+			t.Errorf("PBKDF2 of %q and %q: got %x, want %x", vector.passphrase, vector.salt, derived, expected)
+		}
+	}
+}
+
+func TestOpenIterations(t *testing.T) {
+	sealed, err := seal([]byte("private key"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := sealed.open("correct horse"); err != nil || string(data) != "private key" {
+		t.Fatalf("open: got %q, %v", data, err)
+	}
+
+	for _, iterations := range []int{0, -1, maxKDFIterations + 1} {
+		sealed.Iterations = iterations
+		if _, err = sealed.open("correct horse"); err == nil {
+			t.Errorf("%d iterations accepted", iterations)
+		}
+	}
+}
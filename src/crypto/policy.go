@@ -0,0 +1,49 @@
+package crypto
+
+import "errors"
+
+/*
+How strictly the signatures of received messages are checked
+*/
+type SignaturePolicy string
+
+const (
+	// signatures are ignored
+	PolicyOff SignaturePolicy = "off"
+	// signed messages are verified, unsigned ones are accepted
+	PolicyVerifyIfPresent SignaturePolicy = "verify-if-present"
+	// Hello, PublicKey and Root and their replies must be signed
+	PolicyRequiredForHandshake SignaturePolicy = "required-for-handshake"
+	// every message that carries a signature in the protocol must be signed
+	PolicyRequiredForAll SignaturePolicy = "required-for-all"
+)
+
+func ParsePolicy(name string) (SignaturePolicy, error) {
+	switch policy := SignaturePolicy(name); policy {
+	case PolicyOff, PolicyVerifyIfPresent, PolicyRequiredForHandshake, PolicyRequiredForAll:
+		return policy, nil
+	}
+	return "", errors.New("unknown signature policy: " + name + " (off, verify-if-present, required-for-handshake or required-for-all)")
+}
+
+/*
+Whether the bodies exchanged with peers are encrypted
+*/
+type EncryptionPolicy string
+
+const (
+	// bodies are always sent in clear, the extension is not advertised
+	EncryptionOff EncryptionPolicy = "off"
+	// encrypted with the peers advertising the extension, in clear with the others
+	EncryptionIfAvailable EncryptionPolicy = "if-available"
+	// datums are never exchanged in clear
+	EncryptionRequired EncryptionPolicy = "required"
+)
+
+func ParseEncryption(name string) (EncryptionPolicy, error) {
+	switch policy := EncryptionPolicy(name); policy {
+	case EncryptionOff, EncryptionIfAvailable, EncryptionRequired:
+		return policy, nil
+	}
+	return "", errors.New("unknown encryption policy: " + name + " (off, if-available or required)")
+}
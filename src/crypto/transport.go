@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+var ErrDecryption = errors.New("message could not be decrypted")
+
+/*
+Encrypts the bodies exchanged with one peer with AES-256-GCM, under a key
+derived by ECDH from our identity key and the one of the peer
+*/
+type Transport struct {
+	aead cipher.AEAD
+}
+
+/*
+Derives the key shared with the peer, both sides get the same one from
+their private key and the public key of the other
+*/
+func NewTransport(privateKey *ecdsa.PrivateKey, peerKey []byte) (*Transport, error) {
+	if err := ValidatePublicKey(peerKey); err != nil {
+		return nil, err
+	}
+
+	ours, err := privateKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := ecdh.P256().NewPublicKey(append([]byte{4}, peerKey...))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	secret, err := ours.ECDH(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the shared secret is not used as is, the key is bound to both public keys
+	ourKey := FormatPublicKey(privateKey.PublicKey)
+	first, second := ourKey, peerKey
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("protocoles-internet-2023 transport"))
+	mac.Write(first)
+	mac.Write(second)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transport{aead: aead}, nil
+}
+
+/*
+Encrypts a body, the random nonce is put before it
+The header is authenticated, so a body cannot be moved to another message
+*/
+func (transport *Transport) Seal(header []byte, body []byte) ([]byte, error) {
+	nonce := make([]byte, transport.aead.NonceSize(), transport.aead.NonceSize()+len(body)+transport.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return transport.aead.Seal(nonce, nonce, body, header), nil
+}
+
+func (transport *Transport) Open(header []byte, sealed []byte) ([]byte, error) {
+	size := transport.aead.NonceSize()
+	if len(sealed) < size+transport.aead.Overhead() {
+		return nil, ErrDecryption
+	}
+
+	body, err := transport.aead.Open(nil, sealed[:size], sealed[size:], header)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return body, nil
+}
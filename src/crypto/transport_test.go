@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// transports of two peers talking to each other
+func newTransports(t *testing.T) (*Transport, *Transport) {
+	t.Helper()
+
+	alice, _, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceSide, err := NewTransport(alice, FormatPublicKey(bob.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobSide, err := NewTransport(bob, FormatPublicKey(alice.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aliceSide, bobSide
+}
+
+func TestTransportSealOpen(t *testing.T) {
+	alice, bob := newTransports(t)
+	header := []byte{0, 0, 0, 1, 3}
+	body := []byte("body of a datum")
+
+	sealed, err := alice.Seal(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, body) {
+		t.Fatal("sealed body contains the clear text")
+	}
+
+	opened, err := bob.Open(header, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, body) {
+		t.Fatalf("opened %q, sent %q", opened, body)
+	}
+
+	again, err := alice.Seal(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, sealed) {
+		t.Fatal("same nonce used twice")
+	}
+}
+
+func TestTransportOpenRefused(t *testing.T) {
+	alice, bob := newTransports(t)
+	header := []byte{0, 0, 0, 1, 3}
+
+	sealed, err := alice.Seal(header, []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	other, _ := newTransports(t)
+
+	cases := []struct {
+		name      string
+		transport *Transport
+		header    []byte
+		sealed    []byte
+	}{
+		{"tampered body", bob, header, tampered},
+		{"other header", bob, []byte{0, 0, 0, 2, 3}, sealed},
+		{"too short", bob, header, sealed[:10]},
+		{"other key", other, header, sealed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.transport.Open(c.header, c.sealed); !errors.Is(err, ErrDecryption) {
+				t.Fatalf("got %v, want ErrDecryption", err)
+			}
+		})
+	}
+}
+
+func TestNewTransportInvalidKey(t *testing.T) {
+	privateKey, _, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTransport(privateKey, make([]byte, 64)); err == nil {
+		t.Fatal("key outside of the curve accepted")
+	}
+	if _, err := NewTransport(privateKey, []byte{1, 2, 3}); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
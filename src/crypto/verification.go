@@ -1,16 +1,52 @@
 package crypto
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"errors"
 	"math/big"
 )
 
-func VerifyMessage(data []byte, signature []byte, publicKey *ecdsa.PublicKey) bool {
+var ErrSignatureLength = errors.New("signature must be 64 bytes")
+var ErrInvalidKey = errors.New("public key is not a point of P-256")
+var ErrWrongSignature = errors.New("wrong signature")
+
+/*
+Checks that a key in the format of the protocol, X then Y on 32 bytes each,
+is a point of the curve
+*/
+func ValidatePublicKey(key []byte) error {
+	if len(key) != 64 {
+		return ErrInvalidKey
+	}
+	// uncompressed point encoding
+	if _, err := ecdh.P256().NewPublicKey(append([]byte{4}, key...)); err != nil {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+/*
+Verifies the signature of data, r then s on 32 bytes each, with a key in
+the format of the protocol
+*/
+func VerifySignature(data []byte, signature []byte, key []byte) error {
+	if len(signature) != 64 {
+		return ErrSignatureLength
+	}
+	if err := ValidatePublicKey(key); err != nil {
+		return err
+	}
+
+	publicKey := ParsePublicKey(key)
 
 	var r, s big.Int
 	r.SetBytes(signature[:32])
 	s.SetBytes(signature[32:])
 	hashed := sha256.Sum256(data)
-	return ecdsa.Verify(publicKey, hashed[:], &r, &s)
+	if !ecdsa.Verify(&publicKey, hashed[:], &r, &s) {
+		return ErrWrongSignature
+	}
+	return nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/gateway"
+	"protocoles-internet-2023/logging"
+	"syscall"
+	"time"
+)
+
+// time left to the running gateway and control requests when shutting down
+const shutdownTimeout = 5 * time.Second
+
+/*
+Headless mode: the node serves its files and keeps its association with
+the server until SIGINT or SIGTERM, then shuts down cleanly
+*/
+func runDaemon(gw *gateway.Gateway, ctl *control.Server) {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("running as daemon", "exports", localNode.ExportPaths())
+
+	<-ctx.Done()
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if gw != nil {
+		err := gw.Shutdown(shutdownCtx)
+		if err != nil {
+			logger.Warn("gateway shutdown", "err", err)
+		}
+	}
+
+	err := ctl.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Warn("control API shutdown", "err", err)
+	}
+
+	localNode.Shutdown()
+}
+
+/*
+The logs, and anything still printed on stdout or stderr, are sent to the
+file instead
+*/
+func redirectLogs(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	os.Stdout = file
+	os.Stderr = file
+	logging.SetOutput(file)
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package directory
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/filestructure"
+	"protocoles-internet-2023/logging"
+	udptypes "protocoles-internet-2023/udp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var logger = logging.Logger("directory")
+
+/*
+Stand-in for the directory server (jch.irif.fr), to run a whole network on
+one machine or in tests
+
+Peers register over UDP as with the real server: Hello, PublicKey then Root.
+The REST side publishes what they sent, as long as their session lasts, and
+the server relays NatTraversalRequest to the registered peers. The server is
+a peer itself, listed under its name with its addresses, key and root
+
+Server is an http.Handler, served with httptest.NewServer in tests
+*/
+type Server struct {
+	Name      string
+	Addresses []string // UDP addresses published for the server, its socket by default
+	Scheduler *udptypes.Scheduler
+	Socket    *udptypes.UDPSock
+}
+
+// what the server publishes about a peer
+type entry struct {
+	addresses []string
+	key       []byte
+	root      []byte
+}
+
+/*
+Server "constructor"
+Opens the UDP socket on listen, see udptypes.NewUDPSocket. Without private
+key a new one is generated. Nothing is received before Start
+*/
+func NewServer(name string, listen string, privateKey *ecdsa.PrivateKey) (*Server, error) {
+	if name == "" || strings.ContainsAny(name, "\n\r/") {
+		return nil, errors.New("server name must not be empty nor contain new lines or slashes")
+	}
+
+	if privateKey == nil {
+		var err error
+		privateKey, _, err = crypto.GenerateKeys()
+		if err != nil {
+			return nil, errors.New("generating the keys of the server: " + err.Error())
+		}
+	}
+
+	// the server exports nothing
+	exports, err := (&filestructure.MemoryProvider{Name: name}).Load()
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := udptypes.NewUDPSocket(listen)
+	if err != nil {
+		return nil, errors.New("NewUDPSocket: " + err.Error())
+	}
+
+	srv := Server{
+		Name:      name,
+		Scheduler: udptypes.NewScheduler(*socket, &exports, privateKey, &privateKey.PublicKey),
+		Socket:    socket,
+	}
+	srv.Scheduler.Name = name
+	srv.Scheduler.SignaturePolicy = crypto.PolicyVerifyIfPresent
+	srv.Scheduler.Relay = true
+
+	addr := srv.Addr()
+	if addr.IP.IsUnspecified() {
+		// listening everywhere, the peers on this machine reach it on loopback
+		addr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port}
+	}
+	srv.Addresses = []string{addr.String()}
+
+	return &srv, nil
+}
+
+/*
+Local address of the UDP socket
+*/
+func (srv *Server) Addr() *net.UDPAddr {
+	return srv.Socket.Socket.LocalAddr().(*net.UDPAddr)
+}
+
+/*
+Starts receiving the messages of the peers
+*/
+func (srv *Server) Start() {
+	logger.Info("directory server started", "name", srv.Name, "addresses", srv.Addresses,
+		"key", crypto.Fingerprint(crypto.FormatPublicKey(*srv.Scheduler.PublicKey())))
+	srv.Scheduler.Launch(srv.Socket)
+}
+
+/*
+Closes the socket, which ends the reception loop
+*/
+func (srv *Server) Close() error {
+	return srv.Socket.Socket.Close()
+}
+
+// a peer is published from its Hello until its session expires
+func registered(peer udptypes.PeerSnapshot) bool {
+	return peer.Name != "" && peer.State != udptypes.StateUnknown && peer.State != udptypes.StateExpired
+}
+
+/*
+Names of the registered peers, the server among them, sorted
+*/
+func (srv *Server) Names() []string {
+	names := []string{srv.Name}
+	for _, peer := range srv.Scheduler.Peers.Snapshot() {
+		if registered(peer) && peer.Name != srv.Name && !slices.Contains(names, peer.Name) {
+			names = append(names, peer.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (srv *Server) lookup(name string) (entry, bool) {
+	if name == srv.Name {
+		root := srv.Scheduler.Exports().Hash
+		return entry{
+			addresses: srv.Addresses,
+			key:       crypto.FormatPublicKey(*srv.Scheduler.PublicKey()),
+			root:      root[:],
+		}, true
+	}
+
+	info, ok := srv.Scheduler.PeerByName(name)
+	if !ok {
+		return entry{}, false
+	}
+	peer := info.Snapshot()
+	if !registered(peer) {
+		return entry{}, false
+	}
+
+	// the address the peer last used first
+	var published entry
+	if peer.Address != nil {
+		published.addresses = append(published.addresses, peer.Address.String())
+	}
+	for _, address := range peer.Addresses {
+		if !slices.Contains(published.addresses, address) {
+			published.addresses = append(published.addresses, address)
+		}
+	}
+	// anyone can send the key of another peer, only a proven one is published
+	if peer.KeyProven {
+		published.key = peer.PublicKey
+	}
+	if peer.Root != [32]byte{} {
+		published.root = peer.Root[:]
+	}
+	return published, true
+}
+
+/*
+REST side of the server, as jch.irif.fr serves it
+
+	/peers/                  names of the peers, one per line
+	/peers/{name}/addresses  UDP addresses of the peer, one per line
+	/peers/{name}/key        public key of the peer, 64 bytes
+	/peers/{name}/root       hash of the last root the peer sent, 32 bytes
+
+A peer without key, or that did not prove it holds it, or without root gets
+a 204, an unknown one a 404
+*/
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/peers/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if path == "" {
+		writeBody(w, r, "text/plain; charset=utf-8", lines(srv.Names()))
+		return
+	}
+
+	name, resource, ok := strings.Cut(path, "/")
+	published, found := srv.lookup(name)
+	if !ok || !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch resource {
+	case "addresses":
+		writeBody(w, r, "text/plain; charset=utf-8", lines(published.addresses))
+	case "key":
+		writeBody(w, r, "application/octet-stream", published.key)
+	case "root":
+		writeBody(w, r, "application/octet-stream", published.root)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func lines(values []string) []byte {
+	var body []byte
+	for _, value := range values {
+		body = append(body, value...)
+		body = append(body, '\n')
+	}
+	return body
+}
+
+// an empty body is a 204, an unchanged one a 304 for the clients sending its ETag back
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	if len(body) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := "\"" + hex.EncodeToString(sum[:8]) + "\""
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
@@ -0,0 +1,139 @@
+package directory
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"protocoles-internet-2023/crypto"
+	udptypes "protocoles-internet-2023/udp"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	srv, err := NewServer("jch.irif.fr", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func get(srv *Server, method string, path string, etag string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestServeHTTP(t *testing.T) {
+	srv := newTestServer(t)
+
+	names := get(srv, http.MethodGet, "/peers/", "")
+	if names.Code != http.StatusOK || names.Body.String() != "jch.irif.fr\n" {
+		t.Fatalf("names: %d %q", names.Code, names.Body.String())
+	}
+
+	addresses := get(srv, http.MethodGet, "/peers/jch.irif.fr/addresses", "")
+	if addresses.Code != http.StatusOK || addresses.Body.String() != srv.Addr().String()+"\n" {
+		t.Fatalf("addresses: %d %q, listening on %s", addresses.Code, addresses.Body.String(), srv.Addr())
+	}
+
+	key := get(srv, http.MethodGet, "/peers/jch.irif.fr/key", "")
+	if key.Code != http.StatusOK || key.Body.String() != string(crypto.FormatPublicKey(*srv.Scheduler.PublicKey())) {
+		t.Fatalf("key: %d, %d bytes", key.Code, key.Body.Len())
+	}
+
+	etag := key.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if unchanged := get(srv, http.MethodGet, "/peers/jch.irif.fr/key", etag); unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+		t.Fatalf("unchanged key: %d, %d bytes", unchanged.Code, unchanged.Body.Len())
+	}
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	srv := newTestServer(t)
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/peers/alice/key", http.StatusNotFound},
+		{http.MethodGet, "/peers/jch.irif.fr", http.StatusNotFound},
+		{http.MethodGet, "/peers/jch.irif.fr/other", http.StatusNotFound},
+		{http.MethodGet, "/other", http.StatusNotFound},
+		{http.MethodPost, "/peers/", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		if response := get(srv, c.method, c.path, ""); response.Code != c.status {
+			t.Errorf("%s %s: %d, want %d", c.method, c.path, response.Code, c.status)
+		}
+	}
+}
+
+/*
+Sends the request from sock and waits for its reply
+The challenges of the server are answered without signature
+*/
+func exchange(t *testing.T, sock *udptypes.UDPSock, server *net.UDPAddr, msg udptypes.UDPMessage) udptypes.UDPMessage {
+	t.Helper()
+	msg.Length = uint16(len(msg.Body))
+	if err := sock.SendPacket(msg, server); err != nil {
+		t.Fatal(err)
+	}
+
+	sock.Socket.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		received, _, err := sock.ReceivePacket()
+		if err != nil {
+			t.Fatalf("waiting for the reply to %d: %v", msg.Type, err)
+		}
+		if received.Id == msg.Id {
+			return received
+		}
+		if received.Type == udptypes.Hello {
+			body := udptypes.HelloBody{}.HelloBodyToBytes()
+			reply := udptypes.UDPMessage{Id: received.Id, Type: udptypes.HelloReply, Length: uint16(len(body)), Body: body}
+			sock.SendPacket(reply, server)
+		}
+	}
+}
+
+func TestUnprovenKeyNotPublished(t *testing.T) {
+	srv := newTestServer(t)
+	srv.Start()
+	server := srv.Addr()
+
+	sock, err := udptypes.NewUDPSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Socket.Close()
+
+	// the key of another peer, eve cannot sign the replies to the challenges of the server
+	_, key, err := crypto.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := udptypes.HelloBody{Name: "eve"}.HelloBodyToBytes()
+	exchange(t, sock, server, udptypes.UDPMessage{Id: 1, Type: udptypes.Hello, Body: hello})
+	exchange(t, sock, server, udptypes.UDPMessage{Id: 2, Type: udptypes.PublicKey, Body: crypto.FormatPublicKey(*key)})
+	root := exchange(t, sock, server, udptypes.UDPMessage{Id: 3, Type: udptypes.Root, Body: make([]byte, 32)})
+	if root.Type != udptypes.RootReply {
+		t.Fatalf("reply %d to Root", root.Type)
+	}
+
+	if names := get(srv, http.MethodGet, "/peers/", ""); names.Body.String() != "eve\njch.irif.fr\n" {
+		t.Fatalf("names: %q", names.Body.String())
+	}
+	if published := get(srv, http.MethodGet, "/peers/eve/key", ""); published.Code != http.StatusNoContent {
+		t.Fatalf("key not proven: %d, %d bytes", published.Code, published.Body.Len())
+	}
+}
@@ -2,10 +2,15 @@ package filestructure
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"protocoles-internet-2023/logging"
+	"strings"
 )
 
 // taille max d'un chunk en octets
@@ -26,16 +31,49 @@ func ExpandString(name string) string {
 	return name
 }
 
+/*
+Vérifie un nom reçu d'un pair avant de l'utiliser comme nom de fichier :
+un nom capable de sortir du répertoire de destination est refusé
+*/
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return errors.New("unsafe file name: " + name)
+	}
+	return nil
+}
+
 // Charge le fichier, à partir du chemin donné, et de ses enfants (si c'est un big file)
 func loadFile(path string, name string, data []byte) (File, error) {
-	if len(data) <= ChunkSize {
+	return loadFileStream(nil, path, name, bytes.NewReader(data), 0, int64(len(data)))
+}
+
+/*
+Construit le fichier de taille size en lisant r dans l'ordre, chunk après chunk
+Si provider est nil le contenu des chunks est gardé en mémoire, sinon seul
+son emplacement (path, offset) est retenu pour être relu à la demande
+*/
+func loadFileStream(provider Provider, path string, name string, r io.Reader, offset int64, size int64) (File, error) {
+	if size <= ChunkSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
 		chunk := Chunk{
 			Name: name,
-			Data: data,
 		}
-
-		hash := sha256.Sum256(append([]byte{0}, chunk.Data...))
-		chunk.Hash = hash
+		chunk.Hash = sha256.Sum256(append([]byte{0}, data...))
+
+		if provider == nil {
+			chunk.Data = data
+		} else {
+			chunk.Source = &ChunkSource{
+				Provider: provider,
+				Path:     path,
+				Offset:   offset,
+				Size:     size,
+			}
+		}
 
 		return chunk, nil
 	} else {
@@ -43,18 +81,18 @@ func loadFile(path string, name string, data []byte) (File, error) {
 			Name: name,
 		}
 
-		childSize := (len(data) + MaxChildren - 1) / MaxChildren
+		childSize := (size + MaxChildren - 1) / MaxChildren
 		if childSize < ChunkSize {
 			childSize = ChunkSize
 		}
 
-		for i := 0; i < len(data); i += childSize {
+		for i := int64(0); i < size; i += childSize {
 			end := i + childSize
-			if end > len(data) {
-				end = len(data)
+			if end > size {
+				end = size
 			}
 
-			child, err := loadFile(path, name+fmt.Sprintf(" part %d", i/childSize), data[i:end])
+			child, err := loadFileStream(provider, path, name+fmt.Sprintf(" part %d", i/childSize), r, offset+i, end-i)
 			if err != nil {
 				return nil, err
 			}
@@ -79,6 +117,27 @@ func loadFile(path string, name string, data []byte) (File, error) {
 	}
 }
 
+// Calcule le hash d'un répertoire à partir de ses enfants
+func hashDirectory(node *Directory) {
+	var hashTmp []byte
+
+	for _, child := range node.Data {
+
+		if ch, ok := child.(Chunk); ok {
+			hashTmp = append(hashTmp, []byte(ExpandString(ch.Name))...)
+			hashTmp = append(hashTmp, ch.Hash[:]...)
+		} else if big, ok := child.(Bigfile); ok {
+			hashTmp = append(hashTmp, []byte(ExpandString(big.Name))...)
+			hashTmp = append(hashTmp, big.Hash[:]...)
+		} else if dir, ok := child.(Directory); ok {
+			hashTmp = append(hashTmp, []byte(ExpandString(dir.Name))...)
+			hashTmp = append(hashTmp, dir.Hash[:]...)
+		}
+	}
+
+	node.Hash = sha256.Sum256(append([]byte{2}, hashTmp[:]...))
+}
+
 // Charge le répertoire à partir du chemin donné et de ses enfants
 func LoadDirectory(path string) (File, error) {
 	fileInfo, err := os.Stat(path)
@@ -104,24 +163,7 @@ func LoadDirectory(path string) (File, error) {
 			node.Data = append(node.Data, childFile)
 		}
 
-		// Compute the hash of the directory
-		var hashTmp []byte
-
-		for _, child := range node.Data {
-
-			if ch, ok := child.(Chunk); ok {
-				hashTmp = append(hashTmp, []byte(ExpandString(ch.Name))...)
-				hashTmp = append(hashTmp, ch.Hash[:]...)
-			} else if big, ok := child.(Bigfile); ok {
-				hashTmp = append(hashTmp, []byte(ExpandString(big.Name))...)
-				hashTmp = append(hashTmp, big.Hash[:]...)
-			} else if dir, ok := child.(Directory); ok {
-				hashTmp = append(hashTmp, []byte(ExpandString(dir.Name))...)
-				hashTmp = append(hashTmp, dir.Hash[:]...)
-			}
-		}
-
-		node.Hash = sha256.Sum256(append([]byte{2}, hashTmp[:]...))
+		hashDirectory(&node)
 
 		return node, nil
 	} else {
@@ -134,6 +176,29 @@ func LoadDirectory(path string) (File, error) {
 	}
 }
 
+/*
+Contenu du chunk, lu depuis son Provider s'il n'est pas en mémoire
+Le contenu relu doit toujours correspondre au hash annoncé aux pairs
+*/
+func (chunk Chunk) Content() ([]byte, error) {
+	if chunk.Source == nil {
+		return chunk.Data, nil
+	}
+
+	logger.Log(context.Background(), logging.LevelTrace, "reading chunk", "path", chunk.Source.Path, "offset", chunk.Source.Offset, "size", chunk.Source.Size)
+
+	data, err := chunk.Source.Provider.ReadChunk(chunk.Source.Path, chunk.Source.Offset, chunk.Source.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	if sha256.Sum256(append([]byte{0}, data...)) != chunk.Hash {
+		return nil, errors.New("content of " + chunk.Source.Path + " changed since it was exported")
+	}
+
+	return data, nil
+}
+
 func (root *Node) GetNode(hash [32]byte) File {
 
 	if bytes.Equal(root.Hash[:], hash[:]) {
@@ -170,3 +235,31 @@ func (root *Node) GetNode(hash [32]byte) File {
 
 	return nil
 }
+
+/*
+Copie du répertoire sans les enfants nommés, avec son hash recalculé
+Les enfants gardés sont partagés avec l'original
+*/
+func (dir Directory) Without(names map[string]bool) Directory {
+	filtered := Directory{
+		Name: dir.Name,
+	}
+
+	for _, child := range dir.Data {
+		name := ""
+		switch file := child.(type) {
+		case Chunk:
+			name = file.Name
+		case Bigfile:
+			name = file.Name
+		case Directory:
+			name = file.Name
+		}
+		if !names[name] {
+			filtered.Data = append(filtered.Data, child)
+		}
+	}
+	hashDirectory(&filtered)
+
+	return filtered
+}
@@ -0,0 +1,543 @@
+package filestructure
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"protocoles-internet-2023/logging"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fichiers compressés d'une archive zip lus en même temps
+const maxZipEntries = 8
+
+// journal du chargement et de la lecture des fichiers exportés
+var logger = logging.Logger("filestructure")
+
+// Choisit le Provider selon le chemin : répertoire, archive .tar ou .zip
+func OpenProvider(path string) (Provider, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("opening export", "path", path)
+
+	switch {
+	case fileInfo.IsDir():
+		return &DirectoryProvider{Path: path}, nil
+	case strings.HasSuffix(path, ".tar"):
+		return &TarProvider{Path: path}, nil
+	case strings.HasSuffix(path, ".zip"):
+		return &ZipProvider{Path: path}, nil
+	default:
+		return nil, errors.New("cannot export " + path + ": not a directory, .tar or .zip archive")
+	}
+}
+
+// Nom de la racine d'une archive : son nom de fichier sans extension
+func archiveName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+/*
+Arborescence construite à partir de chemins dans un ordre quelconque
+(entrées d'une archive), les répertoires parents sont créés au besoin
+*/
+type treeBuilder struct {
+	name  string
+	dirs  map[string]*treeBuilder
+	files map[string]File
+}
+
+func newTreeBuilder(name string) *treeBuilder {
+	return &treeBuilder{
+		name:  name,
+		dirs:  make(map[string]*treeBuilder),
+		files: make(map[string]File),
+	}
+}
+
+// Ajoute le répertoire au chemin donné et renvoie son builder
+func (tree *treeBuilder) addDir(dirPath string) *treeBuilder {
+	current := tree
+	for _, name := range strings.Split(path.Clean(dirPath), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		next, ok := current.dirs[name]
+		if !ok {
+			next = newTreeBuilder(name)
+			current.dirs[name] = next
+		}
+		current = next
+	}
+	return current
+}
+
+func (tree *treeBuilder) addFile(filePath string, file File) {
+	dir, _ := path.Split(path.Clean(filePath))
+	tree.addDir(dir).files[path.Base(filePath)] = file
+}
+
+// Construit le répertoire, enfants triés par nom comme os.ReadDir
+func (tree *treeBuilder) build() Directory {
+	node := Directory{
+		Name: tree.name,
+	}
+
+	var names []string
+	for name := range tree.dirs {
+		names = append(names, name)
+	}
+	for name := range tree.files {
+		if _, ok := tree.dirs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if dir, ok := tree.dirs[name]; ok {
+			node.Data = append(node.Data, dir.build())
+		} else {
+			node.Data = append(node.Data, tree.files[name])
+		}
+	}
+
+	hashDirectory(&node)
+
+	return node
+}
+
+// Répertoire sur le disque, les fichiers sont relus à la demande
+type DirectoryProvider struct {
+	Path string
+}
+
+func (provider *DirectoryProvider) Load() (Directory, error) {
+	fileInfo, err := os.Stat(provider.Path)
+	if err != nil {
+		return Directory{}, err
+	}
+	if !fileInfo.IsDir() {
+		return Directory{}, errors.New(provider.Path + " is not a directory")
+	}
+
+	file, err := provider.load(provider.Path, fileInfo)
+	if err != nil {
+		return Directory{}, err
+	}
+
+	return file.(Directory), nil
+}
+
+func (provider *DirectoryProvider) load(filePath string, fileInfo os.FileInfo) (File, error) {
+	if !fileInfo.IsDir() {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return loadFileStream(provider, filePath, fileInfo.Name(), file, 0, fileInfo.Size())
+	}
+
+	node := Directory{
+		Name: fileInfo.Name(),
+	}
+
+	children, err := os.ReadDir(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range children {
+		childInfo, err := child.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		childFile, err := provider.load(filepath.Join(filePath, child.Name()), childInfo)
+		if err != nil {
+			return nil, err
+		}
+		node.Data = append(node.Data, childFile)
+	}
+
+	hashDirectory(&node)
+
+	return node, nil
+}
+
+func (provider *DirectoryProvider) ReadChunk(path string, offset int64, size int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, size)
+	_, err = file.ReadAt(data, offset)
+	if err != nil && !(errors.Is(err, io.EOF) && size == 0) {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// chaque chunk est lu dans un fichier ouvert pour lui
+func (provider *DirectoryProvider) Close() error {
+	return nil
+}
+
+// Compte les octets lus pour connaître la position des fichiers dans l'archive
+type countingReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	reader.offset += int64(n)
+	return n, err
+}
+
+/*
+Archive tar non compressée, exportée sans être extraite
+Le contenu d'un fichier est contigu dans l'archive, un chunk se relit donc
+directement à sa position
+*/
+type TarProvider struct {
+	Path string
+	file *os.File
+}
+
+func (provider *TarProvider) Load() (Directory, error) {
+	if provider.file == nil {
+		file, err := os.Open(provider.Path)
+		if err != nil {
+			return Directory{}, err
+		}
+		provider.file = file
+	}
+
+	counter := &countingReader{r: io.NewSectionReader(provider.file, 0, 1<<62)}
+	reader := tar.NewReader(counter)
+	tree := newTreeBuilder(archiveName(provider.Path))
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Directory{}, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			tree.addDir(header.Name)
+		case tar.TypeReg:
+			// les chunks sont repérés par leur position dans l'archive
+			file, err := loadFileStream(provider, header.Name, path.Base(header.Name), reader, counter.offset, header.Size)
+			if err != nil {
+				return Directory{}, err
+			}
+			tree.addFile(header.Name, file)
+		}
+	}
+
+	return tree.build(), nil
+}
+
+func (provider *TarProvider) ReadChunk(path string, offset int64, size int64) ([]byte, error) {
+	if provider.file == nil {
+		return nil, errors.New("archive " + provider.Path + " is not loaded")
+	}
+
+	data := make([]byte, size)
+	_, err := provider.file.ReadAt(data, offset)
+	if err != nil && !(errors.Is(err, io.EOF) && size == 0) {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (provider *TarProvider) Close() error {
+	if provider.file == nil {
+		return nil
+	}
+	err := provider.file.Close()
+	provider.file = nil
+	return err
+}
+
+/*
+Archive zip exportée sans être extraite
+Les fichiers stockés sans compression se relisent à leur position, les
+autres sont décompressés au fil des chunks demandés, dans l'ordre : un
+chunk précédent oblige à reprendre depuis le début du fichier
+*/
+type ZipProvider struct {
+	Path   string
+	file   *os.File
+	reader *zip.Reader
+	files  map[string]*zip.File
+
+	lock    sync.Mutex
+	entries map[string]*zipEntry // fichiers compressés en cours de lecture
+	used    int64                // horloge des lectures, pour fermer la plus ancienne
+}
+
+// Fichier compressé décompressé jusqu'à offset
+type zipEntry struct {
+	content io.ReadCloser
+	offset  int64
+	used    int64
+}
+
+func (provider *ZipProvider) Load() (Directory, error) {
+	if provider.file == nil {
+		file, err := os.Open(provider.Path)
+		if err != nil {
+			return Directory{}, err
+		}
+		fileInfo, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return Directory{}, err
+		}
+		reader, err := zip.NewReader(file, fileInfo.Size())
+		if err != nil {
+			file.Close()
+			return Directory{}, err
+		}
+
+		provider.file = file
+		provider.reader = reader
+	}
+
+	provider.closeEntries()
+	provider.files = make(map[string]*zip.File)
+	tree := newTreeBuilder(archiveName(provider.Path))
+
+	for _, zipFile := range provider.reader.File {
+		if strings.HasSuffix(zipFile.Name, "/") {
+			tree.addDir(zipFile.Name)
+			continue
+		}
+
+		content, err := zipFile.Open()
+		if err != nil {
+			return Directory{}, err
+		}
+
+		file, err := loadFileStream(provider, zipFile.Name, path.Base(zipFile.Name), content, 0, int64(zipFile.UncompressedSize64))
+		content.Close()
+		if err != nil {
+			return Directory{}, err
+		}
+
+		provider.files[zipFile.Name] = zipFile
+		tree.addFile(zipFile.Name, file)
+	}
+
+	return tree.build(), nil
+}
+
+func (provider *ZipProvider) ReadChunk(path string, offset int64, size int64) ([]byte, error) {
+	zipFile, ok := provider.files[path]
+	if !ok {
+		return nil, errors.New("no file " + path + " in archive " + provider.Path)
+	}
+
+	data := make([]byte, size)
+
+	if zipFile.Method == zip.Store {
+		dataOffset, err := zipFile.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		_, err = provider.file.ReadAt(data, dataOffset+offset)
+		if err != nil && !(errors.Is(err, io.EOF) && size == 0) {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	entry, err := provider.entry(path, zipFile, offset)
+	if err == nil {
+		_, err = io.CopyN(io.Discard, entry.content, offset-entry.offset)
+	}
+	if err == nil {
+		_, err = io.ReadFull(entry.content, data)
+	}
+	if err != nil {
+		provider.closeEntry(path)
+		return nil, err
+	}
+	entry.offset = offset + size
+
+	return data, nil
+}
+
+// Le fichier décompressé au plus jusqu'à offset, rouvert au besoin
+func (provider *ZipProvider) entry(path string, zipFile *zip.File, offset int64) (*zipEntry, error) {
+	provider.used++
+	entry, ok := provider.entries[path]
+	if ok && entry.offset <= offset {
+		entry.used = provider.used
+		return entry, nil
+	}
+	provider.closeEntry(path)
+
+	if provider.entries == nil {
+		provider.entries = make(map[string]*zipEntry)
+	}
+	if len(provider.entries) >= maxZipEntries {
+		oldest := ""
+		for entryPath, entry := range provider.entries {
+			if oldest == "" || entry.used < provider.entries[oldest].used {
+				oldest = entryPath
+			}
+		}
+		provider.closeEntry(oldest)
+	}
+
+	content, err := zipFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	entry = &zipEntry{content: content, used: provider.used}
+	provider.entries[path] = entry
+	return entry, nil
+}
+
+func (provider *ZipProvider) closeEntry(path string) {
+	if entry, ok := provider.entries[path]; ok {
+		entry.content.Close()
+		delete(provider.entries, path)
+	}
+}
+
+func (provider *ZipProvider) closeEntries() {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	for path := range provider.entries {
+		provider.closeEntry(path)
+	}
+}
+
+func (provider *ZipProvider) Close() error {
+	provider.closeEntries()
+	if provider.file == nil {
+		return nil
+	}
+	err := provider.file.Close()
+	provider.file = nil
+	provider.reader = nil
+	return err
+}
+
+/*
+Arborescence en mémoire, pratique pour les tests
+Les clés de Files sont des chemins séparés par des /, un chemin terminé
+par / désigne un répertoire vide
+*/
+type MemoryProvider struct {
+	Name  string
+	Files map[string][]byte
+}
+
+func (provider *MemoryProvider) Load() (Directory, error) {
+	tree := newTreeBuilder(provider.Name)
+
+	for filePath, data := range provider.Files {
+		if strings.HasSuffix(filePath, "/") {
+			tree.addDir(filePath)
+			continue
+		}
+
+		file, err := loadFileStream(provider, filePath, path.Base(filePath), bytes.NewReader(data), 0, int64(len(data)))
+		if err != nil {
+			return Directory{}, err
+		}
+		tree.addFile(filePath, file)
+	}
+
+	return tree.build(), nil
+}
+
+func (provider *MemoryProvider) Close() error {
+	return nil
+}
+
+func (provider *MemoryProvider) ReadChunk(path string, offset int64, size int64) ([]byte, error) {
+	data, ok := provider.Files[path]
+	if !ok || offset+size > int64(len(data)) {
+		return nil, errors.New("no such chunk in memory tree")
+	}
+
+	return data[offset : offset+size], nil
+}
+
+/*
+Plusieurs exports réunis sous une même racine, chacun devient un
+sous-répertoire portant son nom
+*/
+type MultiProvider struct {
+	Name      string
+	Providers []Provider
+}
+
+func (provider *MultiProvider) Load() (Directory, error) {
+	root := Directory{
+		Name: provider.Name,
+	}
+
+	names := make(map[string]bool)
+	for _, child := range provider.Providers {
+		dir, err := child.Load()
+		if err != nil {
+			return Directory{}, err
+		}
+		if names[dir.Name] {
+			return Directory{}, errors.New("two exports are named " + dir.Name)
+		}
+		names[dir.Name] = true
+
+		root.Data = append(root.Data, dir)
+	}
+
+	sort.Slice(root.Data, func(i, j int) bool {
+		return root.Data[i].(Directory).Name < root.Data[j].(Directory).Name
+	})
+	hashDirectory(&root)
+
+	return root, nil
+}
+
+// les chunks désignent directement le Provider qui les a chargés
+func (provider *MultiProvider) ReadChunk(path string, offset int64, size int64) ([]byte, error) {
+	return nil, errors.New("chunks are read from the export they belong to")
+}
+
+func (provider *MultiProvider) Close() error {
+	var errs []error
+	for _, child := range provider.Providers {
+		errs = append(errs, child.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,254 @@
+package filestructure
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// un petit fichier et un fichier sur plusieurs niveaux de bigfiles
+func testFiles() map[string][]byte {
+	big := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(big)
+	return map[string][]byte{
+		"hello.txt":    []byte("hello\n"),
+		"docs/big.bin": big,
+	}
+}
+
+func writeTar(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export.tar")
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	for name, data := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeZip(t *testing.T, files map[string][]byte, method uint16) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export.zip")
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for name, data := range files {
+		file, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = file.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// le fichier au chemin donné sous dir
+func find(t *testing.T, dir Directory, path string) File {
+	t.Helper()
+	var file File = dir
+	for _, name := range strings.Split(path, "/") {
+		current, ok := file.(Directory)
+		if !ok {
+			t.Fatalf("%s: %s is not a directory", path, current.Name)
+		}
+		file = nil
+		for _, child := range current.Data {
+			if fileName(child) == name {
+				file = child
+			}
+		}
+		if file == nil {
+			t.Fatalf("no %s in %s", name, current.Name)
+		}
+	}
+	return file
+}
+
+func fileName(file File) string {
+	switch file := file.(type) {
+	case Directory:
+		return file.Name
+	case Bigfile:
+		return file.Name
+	case Chunk:
+		return file.Name
+	}
+	return ""
+}
+
+// les chunks du fichier, dans l'ordre
+func chunks(file File) []Chunk {
+	switch file := file.(type) {
+	case Chunk:
+		return []Chunk{file}
+	case Bigfile:
+		var all []Chunk
+		for _, child := range file.Data {
+			all = append(all, chunks(child)...)
+		}
+		return all
+	}
+	return nil
+}
+
+// lit les chunks dans l'ordre donné et les remet en place
+func readChunks(t *testing.T, file File, order []int) []byte {
+	t.Helper()
+	all := chunks(file)
+	contents := make([][]byte, len(all))
+	for _, i := range order {
+		data, err := all[i].Content()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[i] = data
+	}
+	return bytes.Join(contents, nil)
+}
+
+func checkProvider(t *testing.T, provider Provider, files map[string][]byte) Directory {
+	t.Helper()
+	root, err := provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { provider.Close() })
+
+	for path, data := range files {
+		file := find(t, root, path)
+		count := len(chunks(file))
+		inOrder := make([]int, count)
+		for i := range inOrder {
+			inOrder[i] = i
+		}
+		if content := readChunks(t, file, inOrder); !bytes.Equal(content, data) {
+			t.Fatalf("%s read in order differs", path)
+		}
+
+		// des chunks précédents obligent à relire depuis le début
+		backwards := slices.Clone(inOrder)
+		slices.Reverse(backwards)
+		if content := readChunks(t, file, backwards); !bytes.Equal(content, data) {
+			t.Fatalf("%s read backwards differs", path)
+		}
+	}
+	return root
+}
+
+func TestArchiveProviders(t *testing.T) {
+	files := testFiles()
+
+	dir := t.TempDir()
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := find(t, checkProvider(t, &DirectoryProvider{Path: dir}, files), "docs").(Directory).Hash
+
+	providers := map[string]Provider{
+		"tar":          &TarProvider{Path: writeTar(t, files)},
+		"zip stored":   &ZipProvider{Path: writeZip(t, files, zip.Store)},
+		"zip deflated": &ZipProvider{Path: writeZip(t, files, zip.Deflate)},
+	}
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			root := checkProvider(t, provider, files)
+			if root.Name != "export" {
+				t.Fatalf("root named %q, want the name of the archive", root.Name)
+			}
+			if find(t, root, "docs").(Directory).Hash != expected {
+				t.Fatal("tree differs from the one of the same files in a directory")
+			}
+		})
+	}
+}
+
+func TestZipProviderClosed(t *testing.T) {
+	files := testFiles()
+	provider := &ZipProvider{Path: writeZip(t, files, zip.Deflate)}
+	root, err := provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := chunks(find(t, root, "docs/big.bin"))[0]
+	if _, err = chunk.Content(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = provider.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = chunk.Content(); err == nil {
+		t.Fatal("chunk read from a closed archive")
+	}
+}
+
+func TestMultiProvider(t *testing.T) {
+	files := testFiles()
+	tarProvider := &TarProvider{Path: writeTar(t, files)}
+	provider := &MultiProvider{
+		Name: "alice",
+		Providers: []Provider{
+			&MemoryProvider{Name: "notes", Files: map[string][]byte{"todo.txt": []byte("todo\n")}},
+			tarProvider,
+		},
+	}
+
+	root, err := provider.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, child := range root.Data {
+		names = append(names, fileName(child))
+	}
+	if root.Name != "alice" || !slices.Equal(names, []string{"export", "notes"}) {
+		t.Fatalf("root %s with %v, want alice with export and notes", root.Name, names)
+	}
+	if content := readChunks(t, find(t, root, "notes/todo.txt"), []int{0}); string(content) != "todo\n" {
+		t.Fatalf("todo.txt is %q", content)
+	}
+
+	if err = provider.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tarProvider.file != nil {
+		t.Fatal("archive left open")
+	}
+
+	// deux exports du même nom
+	provider.Providers = append(provider.Providers, &MemoryProvider{Name: "notes"})
+	if _, err = provider.Load(); err == nil {
+		t.Fatal("two exports with the same name accepted")
+	}
+}
@@ -27,7 +27,7 @@ func handleBigfile(bigfile Bigfile) ([]byte, error) {
 }
 
 func SaveFileStructure(path string, node File) error {
-	fmt.Println("Saving : ", path)
+	logger.Info("saving", "path", path)
 	switch node := node.(type) {
 	case Chunk:
 		return os.WriteFile(path, node.Data, 0644)
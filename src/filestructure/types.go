@@ -15,9 +15,29 @@ type Node struct {
 }
 
 type Chunk struct {
-	Name string
-	Hash [32]byte
-	Data []byte
+	Name   string
+	Hash   [32]byte
+	Data   []byte
+	Source *ChunkSource // contenu lu à la demande quand Data n'est pas chargé
+}
+
+// Emplacement du contenu d'un chunk dans un Provider
+type ChunkSource struct {
+	Provider Provider
+	Path     string
+	Offset   int64
+	Size     int64
+}
+
+/*
+Source d'une arborescence exportée
+Load construit l'arbre et calcule les hashs, le contenu des chunks
+n'est ensuite lu qu'à la demande avec ReadChunk, jusqu'à Close
+*/
+type Provider interface {
+	Load() (Directory, error)
+	ReadChunk(path string, offset int64, size int64) ([]byte, error)
+	Close() error
 }
 
 type Bigfile Node
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	udptypes "protocoles-internet-2023/udp"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoRange             = errors.New("no single byte range")
+	errRangeNotSatisfiable = errors.New("range starts after the end of the file")
+)
+
+/*
+First and last byte of a Range header with a single range, for a file of
+the given size: from an offset, open-ended, or the last bytes of the file
+Several ranges and malformed headers give errNoRange, the whole file is
+then sent
+*/
+func parseRange(header string, size int64) (first int64, last int64, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, errNoRange
+	}
+
+	from, to, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, errNoRange
+	}
+
+	if from == "" {
+		suffix, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errNoRange
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		return max(size-suffix, 0), size - 1, nil
+	}
+
+	first, err = strconv.ParseInt(from, 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, errNoRange
+	}
+	last = size - 1
+	if to != "" {
+		last, err = strconv.ParseInt(to, 10, 64)
+		if err != nil || last < first {
+			return 0, 0, errNoRange
+		}
+		last = min(last, size-1)
+	}
+
+	if first >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return first, last, nil
+}
+
+/*
+True when the If-None-Match header lists the tag: the comparison is weak,
+a W/ prefix is ignored, and * matches any
+*/
+func etagMatches(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+For a Range request the size of the file is computed first, from the cache
+or by fetching the bigfiles down to the chunks, then the range is streamed
+Other requests are streamed as the chunks arrive
+*/
+func (gw *Gateway) serveFile(w http.ResponseWriter, r *http.Request, node udptypes.RemoteNode, dest *net.UDPAddr) {
+
+	etag := w.Header().Get("Etag")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(node.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	header := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		// the file changed since the client got its first part
+		header = ""
+	}
+
+	if header == "" {
+		gw.copyFile(w, r, node, dest, 0, -1)
+		return
+	}
+
+	size, err := gw.Scheduler.NodeSize(node, dest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	total := strconv.FormatInt(size, 10)
+
+	first, last, err := parseRange(header, size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		w.Header().Del("Content-Type")
+		w.Header().Set("Content-Range", "bytes */"+total)
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Length", total)
+		gw.copyFile(w, r, node, dest, 0, -1)
+		return
+	}
+
+	w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(first, 10)+"-"+strconv.FormatInt(last, 10)+"/"+total)
+	w.Header().Set("Content-Length", strconv.FormatInt(last-first+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	gw.copyFile(w, r, node, dest, first, last-first+1)
+}
+
+// the headers are sent, an error can only cut the body short
+func (gw *Gateway) copyFile(w http.ResponseWriter, r *http.Request, node udptypes.RemoteNode, dest *net.UDPAddr, offset int64, length int64) {
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := gw.Scheduler.CopyFile(w, node, dest, offset, length); err != nil {
+		logger.Warn("streaming interrupted", "file", node.Name, "err", err)
+	}
+}
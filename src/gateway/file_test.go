@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"protocoles-internet-2023/filestructure"
+	udptypes "protocoles-internet-2023/udp"
+	"strconv"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		first, last int64
+		err         error
+	}{
+		{"bytes=0-99", 0, 99, nil},
+		{"bytes=10-", 10, 999, nil},
+		{"bytes=990-2000", 990, 999, nil},
+		{"bytes=-100", 900, 999, nil},
+		{"bytes=-5000", 0, 999, nil},
+		{"bytes=1000-", 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, errRangeNotSatisfiable},
+		{"bytes=0-1,5-6", 0, 0, errNoRange},
+		{"bytes=10-5", 0, 0, errNoRange},
+		{"bytes=-", 0, 0, errNoRange},
+		{"bytes=a-b", 0, 0, errNoRange},
+		{"lines=0-1", 0, 0, errNoRange},
+	}
+	for _, test := range tests {
+		first, last, err := parseRange(test.header, 1000)
+		if !errors.Is(err, test.err) || err == nil && (first != test.first || last != test.last) {
+			t.Errorf("%s: got %d-%d %v, want %d-%d %v", test.header, first, last, err, test.first, test.last, test.err)
+		}
+	}
+
+	if _, _, err := parseRange("bytes=-10", 0); !errors.Is(err, errRangeNotSatisfiable) {
+		t.Errorf("suffix of an empty file: got %v", err)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	for header, expected := range map[string]bool{
+		`"abc"`:        true,
+		`W/"abc"`:      true,
+		`"x", "abc"`:   true,
+		`*`:            true,
+		`"abd"`:        false,
+		`"x",W/"y"`:    false,
+		``:             false,
+		`abc`:          false,
+		` W/"abc" , *`: true,
+	} {
+		if etagMatches(header, etag) != expected {
+			t.Errorf("If-None-Match %s: want %v", header, expected)
+		}
+	}
+}
+
+/*
+Gateway whose scheduler already holds a bigfile of three chunks: the file is
+served from the cache, no peer is asked
+*/
+func newCachedFile(t *testing.T) (*Gateway, udptypes.RemoteNode, []byte) {
+	t.Helper()
+	sched := udptypes.NewScheduler(udptypes.UDPSock{}, nil, nil, nil)
+
+	var content []byte
+	file := udptypes.RemoteNode{Name: "notes.txt", Type: udptypes.BigfileNode}
+	for i, part := range []string{"first chunk\n", "second chunk\n", "third\n"} {
+		chunk := udptypes.RemoteNode{Type: udptypes.ChunkNode, Data: []byte(part), Hash: sha256.Sum256([]byte(part))}
+		sched.Cache.Nodes[chunk.Hash] = chunk
+		file.Children = append(file.Children, filestructure.Child{Hash: chunk.Hash, Name: strconv.Itoa(i)})
+		content = append(content, part...)
+	}
+	file.Hash = sha256.Sum256(content)
+
+	return NewGateway(sched, nil), file, content
+}
+
+func serveCachedFile(gw *Gateway, file udptypes.RemoteNode, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/peers/bob/notes.txt", nil)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Etag", `"notes"`)
+	gw.serveFile(recorder, request, file, nil)
+	return recorder
+}
+
+func TestServeFileRange(t *testing.T) {
+	gw, file, content := newCachedFile(t)
+	total := "/" + strconv.Itoa(len(content))
+
+	tests := []struct {
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{nil, http.StatusOK, "", string(content)},
+		{map[string]string{"Range": "bytes=6-17"}, http.StatusPartialContent, "bytes 6-17" + total, string(content[6:18])},
+		{map[string]string{"Range": "bytes=-6"}, http.StatusPartialContent, "bytes 25-30" + total, "third\n"},
+		{map[string]string{"Range": "bytes=12-"}, http.StatusPartialContent, "bytes 12-30" + total, string(content[12:])},
+		{map[string]string{"Range": "bytes=31-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */31", ""},
+		{map[string]string{"Range": "bytes=0-1,4-5"}, http.StatusOK, "", string(content)},
+		{map[string]string{"Range": "bytes=0-1", "If-Range": `"older"`}, http.StatusOK, "", string(content)},
+		{map[string]string{"Range": "bytes=0-1", "If-Range": `"notes"`}, http.StatusPartialContent, "bytes 0-1" + total, "fi"},
+	}
+	for _, test := range tests {
+		response := serveCachedFile(gw, file, test.headers)
+		if response.Code != test.status || response.Header().Get("Content-Range") != test.contentRange {
+			t.Errorf("%v: got %d with Content-Range %q, want %d with %q", test.headers,
+				response.Code, response.Header().Get("Content-Range"), test.status, test.contentRange)
+			continue
+		}
+		if test.status != http.StatusRequestedRangeNotSatisfiable && response.Body.String() != test.body {
+			t.Errorf("%v: got %q, want %q", test.headers, response.Body, test.body)
+		}
+		if test.status == http.StatusPartialContent && response.Header().Get("Content-Length") != strconv.Itoa(len(test.body)) {
+			t.Errorf("%v: Content-Length %s for %d bytes", test.headers, response.Header().Get("Content-Length"), len(test.body))
+		}
+	}
+}
+
+func TestServeFileNotModified(t *testing.T) {
+	gw, file, _ := newCachedFile(t)
+
+	for _, header := range []string{`"notes"`, `W/"notes"`, `"other", "notes"`, `*`} {
+		response := serveCachedFile(gw, file, map[string]string{"If-None-Match": header})
+		if response.Code != http.StatusNotModified || response.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: got %d", header, response.Code)
+		}
+	}
+	if response := serveCachedFile(gw, file, map[string]string{"If-None-Match": `"other"`}); response.Code != http.StatusOK {
+		t.Errorf("other tag: got %d", response.Code)
+	}
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"protocoles-internet-2023/logging"
+	"protocoles-internet-2023/rest"
+	udptypes "protocoles-internet-2023/udp"
+	"strings"
+)
+
+var logger = logging.Logger("gateway")
+
+/*
+Local HTTP server exposing the trees of the peers registered on the REST server
+
+	/peers/                 list of the peers
+	/peers/{name}/          root directory of a peer
+	/peers/{name}/{path}    directory listing or file content
+
+Nothing is downloaded in advance, every request fetches the datums it needs
+*/
+type Gateway struct {
+	Scheduler *udptypes.Scheduler
+	Directory *rest.Client
+	server    *http.Server
+}
+
+func NewGateway(sched *udptypes.Scheduler, directory *rest.Client) *Gateway {
+	gw := &Gateway{
+		Scheduler: sched,
+		Directory: directory,
+	}
+	gw.server = &http.Server{Handler: gw}
+
+	return gw
+}
+
+/*
+Starts serving on the given address, which must be a loopback one
+since the gateway has no authentication
+*/
+func (gw *Gateway) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return errors.New("gateway must listen on a loopback address")
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	err = gw.server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+/*
+Stops accepting connections and waits for the running requests to end
+*/
+func (gw *Gateway) Shutdown(ctx context.Context) error {
+	return gw.server.Shutdown(ctx)
+}
+
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logger.Debug("request", "method", r.Method, "path", r.URL.Path)
+
+	if r.URL.Path == "/" || r.URL.Path == "/peers" {
+		http.Redirect(w, r, "/peers/", http.StatusMovedPermanently)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/peers/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if path == "" {
+		gw.servePeers(w, r)
+		return
+	}
+
+	peerName, filePath, found := strings.Cut(path, "/")
+	if !found {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	gw.serveNode(w, r, peerName, filePath)
+}
+
+func (gw *Gateway) servePeers(w http.ResponseWriter, r *http.Request) {
+	peers, err := gw.Directory.GetPeersNames(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	names := make([]string, len(peers))
+	for i, peer := range peers {
+		names[i] = peer + "/"
+	}
+
+	writeListing(w, names)
+}
+
+func (gw *Gateway) serveNode(w http.ResponseWriter, r *http.Request, peerName string, filePath string) {
+
+	var dest *net.UDPAddr
+	addrs, err := gw.Directory.ResolvePeerAddresses(r.Context(), peerName)
+	if errors.Is(err, rest.ErrNotFound) {
+		http.Error(w, "no peer named "+peerName, http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		dest, err = gw.Scheduler.Reach(peerName, addrs)
+	}
+	if err != nil {
+		http.Error(w, "resolving peer: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	root, err := gw.Scheduler.FetchRoot(dest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	node, err := gw.Scheduler.ResolvePath(root, filePath, dest)
+	if errors.Is(err, udptypes.ErrNotFound) || errors.Is(err, udptypes.ErrNotDirectory) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	etag := `"` + hex.EncodeToString(node.Hash[:]) + `"`
+	w.Header().Set("Etag", etag)
+
+	if node.Type == udptypes.DirectoryNode {
+		if filePath != "" && !strings.HasSuffix(filePath, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		names := make([]string, len(node.Children))
+		for i, child := range node.Children {
+			names[i] = child.Name
+			if child, err := gw.Scheduler.FetchNode(child.Hash, dest); err == nil && child.Type == udptypes.DirectoryNode {
+				names[i] += "/"
+			}
+		}
+		writeListing(w, names)
+		return
+	}
+
+	gw.serveFile(w, r, node, dest)
+}
+
+/*
+Same layout as the listings of http.FileServer so that browsers and scripts
+written against it feel at home
+*/
+func writeListing(w http.ResponseWriter, names []string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	fmt.Fprintln(w, "<!doctype html>")
+	fmt.Fprintln(w, "<pre>")
+	for _, name := range names {
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
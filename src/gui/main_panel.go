@@ -1,26 +1,22 @@
 package gui
 
 import (
-	"fmt"
+	"errors"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
-	"log"
-	mrand "math/rand"
-	"net"
-	"protocoles-internet-2023/config"
-	"protocoles-internet-2023/crypto"
-	"protocoles-internet-2023/filestructure"
-	"protocoles-internet-2023/rest"
-	udptypes "protocoles-internet-2023/udp"
-	"time"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/logging"
 )
 
+var logger = logging.Logger("gui")
+
 var peersNames []string
 
-func Init(scheduler *udptypes.Scheduler, ENDPOINT string) fyne.Window {
+func Init(client *control.Client) fyne.Window {
 	appli := app.New()
 	window := appli.NewWindow("Peer to peer file transfer")
 	window.Resize(fyne.NewSize(848, 480))
@@ -45,180 +41,48 @@ func Init(scheduler *udptypes.Scheduler, ENDPOINT string) fyne.Window {
 	}
 
 	refreshPeersButton := widget.NewButton("Refresh", func() {
-		RefreshPeersNames(ENDPOINT)
+		RefreshPeersNames(client)
 	})
 
 	leftPanel := container.NewBorder(widget.NewLabel("Registered peers"), refreshPeersButton, nil, nil, peerNamesListWidget)
 
+	buttonConnect := widget.NewButton("Connect", func() {
+		peerAction(window, client, "Connect", selectedPeer, client.Connect)
+	})
 	buttonHello := widget.NewButton("Hello", func() {
-
-		if selectedPeer == "" {
-			fmt.Println("no peer selected")
-			return
-		}
-
-		ipString, err := rest.GetPeerAddresses(ENDPOINT, selectedPeer)
-		if err != nil {
-			fmt.Println("Hello button: ", err.Error())
-			return
-		}
-		ip, err := net.ResolveUDPAddr("udp", ipString[0])
-
-		scheduler.SendHello(ip)
+		peerAction(window, client, "Hello", selectedPeer, client.Hello)
 	})
 	buttonPublicKey := widget.NewButton("PublicKey", func() {
-
-		if selectedPeer == "" {
-			fmt.Println("no peer selected")
-			return
-		}
-
-		ipString, err := rest.GetPeerAddresses(ENDPOINT, selectedPeer)
-		if err != nil {
-			fmt.Println("PublicKey button: ", err.Error())
-			return
-		}
-		ip, err := net.ResolveUDPAddr("udp", ipString[0])
-
-		scheduler.SendPublicKey(ip)
+		peerAction(window, client, "PublicKey", selectedPeer, client.PublicKey)
 	})
 	buttonRoot := widget.NewButton("Root", func() {
-
-		if selectedPeer == "" {
-			fmt.Println("no peer selected")
-			return
-		}
-
-		ipString, err := rest.GetPeerAddresses(ENDPOINT, selectedPeer)
-		if err != nil {
-			fmt.Println("Root button: ", err.Error())
-			return
-		}
-		ip, err := net.ResolveUDPAddr("udp", ipString[0])
-
-		scheduler.SendRoot(ip)
+		peerAction(window, client, "Root", selectedPeer, client.Root)
 	})
 	buttonNoOp := widget.NewButton("NoOp", func() {
-
-		if selectedPeer == "" {
-			fmt.Println("no peer selected")
-			return
-		}
-
-		ipString, err := rest.GetPeerAddresses(ENDPOINT, selectedPeer)
-		if err != nil {
-			fmt.Println("NoOp button: ", err.Error())
-			return
-		}
-		ip, err := net.ResolveUDPAddr("udp", ipString[0])
-
-		scheduler.SendNoOp(ip)
+		peerAction(window, client, "NoOp", selectedPeer, client.NoOp)
 	})
 	buttonDownload := widget.NewButton("Download files", func() {
 		if selectedPeer == "" {
-			fmt.Println("no peer selected")
-			return
-		}
-
-		ipString, err := rest.GetPeerAddresses(ENDPOINT, selectedPeer)
-		if err != nil {
-			fmt.Println("NoOp button: ", err.Error())
-			return
-		}
-		peerIP, err := net.ResolveUDPAddr("udp", ipString[0])
-
-		msgBody := udptypes.HelloBody{
-			Extensions: 0,
-			Name:       config.ClientName,
-		}.HelloBodyToBytes()
-
-		msg := udptypes.UDPMessage{
-			Id:         uint32(mrand.Int31()),
-			Type:       udptypes.Hello,
-			Length:     uint16(len(msgBody)),
-			Body:       msgBody,
-			PrivateKey: scheduler.PrivateKey,
-		}
-
-		_, err = scheduler.SendPacket(msg, peerIP)
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
-
-		msg = udptypes.UDPMessage{
-			Id:         uint32(mrand.Int31()),
-			Type:       udptypes.PublicKey,
-			Length:     64,
-			Body:       crypto.FormatPublicKey(*scheduler.PublicKey),
-			PrivateKey: scheduler.PrivateKey,
-		}
-		_, err = scheduler.SendPacket(msg, peerIP)
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
-
-		msg = udptypes.UDPMessage{
-			Id:         uint32(mrand.Int31()),
-			Type:       udptypes.Root,
-			Length:     32,
-			Body:       scheduler.ExportedFiles.Hash[:],
-			PrivateKey: scheduler.PrivateKey,
-		}
-		_, err = scheduler.SendPacket(msg, peerIP)
-		if err != nil {
-			fmt.Println(err.Error())
+			logger.Info("no peer selected")
 			return
 		}
 
-		peer := scheduler.PeerDatabase[peerIP.String()]
-		downloadedNode := &filestructure.Directory{}
-
-		datumRoot := udptypes.UDPMessage{
-			Id:     uint32(mrand.Int31()),
-			Type:   udptypes.GetDatum,
-			Length: 32,
-			Body:   peer.Root[:],
-		}
-
-		packet, err := scheduler.SendPacket(datumRoot, peerIP)
-		if err != nil {
-			log.Fatal("Could not send GetDatum packet: ", err.Error())
-		}
-
-		node := packet
-		if packet.Packet.Type == udptypes.NoDatum {
-			fmt.Println("No datum received")
+		// the node saves the whole tree of the peer in its download directory
+		download, err := client.StartDownload(control.DownloadRequest{
+			Peer: selectedPeer,
+		})
+		if errors.Is(err, control.ErrKeyChanged) {
+			keyChanged(window, client, selectedPeer, err)
 			return
-		}
-		body := udptypes.BytesToDatumBody(node.Packet.Body)
-
-		for i := 1; i < len(body.Value); i += 64 {
-			child := filestructure.Child{
-				Name: string(body.Value[i : i+32]),
-				Hash: [32]byte(body.Value[i+32 : i+64]),
-			}
-
-			downloadedNode.Children = append(downloadedNode.Children, child)
-		}
-
-		downloadedNode.Name = peer.Name + "-" + time.Now().Format("2006-01-02_15-04")
-
-		newNode, err := scheduler.DownloadNode((*filestructure.Node)(downloadedNode), peerIP.String())
-		if err != nil {
-			fmt.Println("Download files:", err.Error())
+		} else if err != nil {
+			logger.Warn("download failed", "peer", selectedPeer, "err", err)
 			return
 		}
 
-		err = filestructure.SaveFileStructure("../"+newNode.Name, *(*filestructure.Directory)(newNode))
-		if err != nil {
-			fmt.Println("saving file structure: ", err.Error())
-		}
-
+		logger.Info("downloading", "peer", selectedPeer, "id", download.Id, "dest", download.Dest)
 	})
 
-	vboxButtons := container.New(layout.NewVBoxLayout(), buttonHello, buttonRoot, buttonNoOp, buttonPublicKey, buttonDownload)
+	vboxButtons := container.New(layout.NewVBoxLayout(), buttonConnect, buttonHello, buttonRoot, buttonNoOp, buttonPublicKey, buttonDownload)
 
 	buttonArea := container.NewBorder(widget.NewLabel("Actions"), nil, nil, nil, vboxButtons)
 
@@ -227,11 +91,60 @@ func Init(scheduler *udptypes.Scheduler, ENDPOINT string) fyne.Window {
 	return window
 }
 
-func RefreshPeersNames(ENDPOINT string) {
-	peersNamesList, err := rest.GetPeersNames(ENDPOINT)
+func RefreshPeersNames(client *control.Client) {
+	peers, err := client.Peers()
 	if err != nil {
-		fmt.Println("Fetching peers names: " + err.Error())
+		logger.Warn("fetching peers names", "err", err)
+		return
+	}
+
+	peersNamesList := make([]string, len(peers))
+	for i, peer := range peers {
+		peersNamesList[i] = peer.Name
 	}
 
 	peersNames = peersNamesList
 }
+
+/*
+Sends the request of a button to the selected peer through the node
+*/
+func peerAction(window fyne.Window, client *control.Client, button string, peer string, action func(string) (control.PeerStatus, error)) {
+	if peer == "" {
+		logger.Info("no peer selected")
+		return
+	}
+
+	status, err := action(peer)
+	if errors.Is(err, control.ErrKeyChanged) {
+		keyChanged(window, client, peer, err)
+		return
+	} else if err != nil {
+		logger.Warn(button+" failed", "peer", peer, "err", err)
+		return
+	}
+
+	logger.Debug(button+" answered", "peer", peer, "addr", status.Address, "rtt_ms", status.RTT)
+}
+
+/*
+Warns that the key of the peer is not the pinned one, the new key is only
+trusted if the user accepts it
+*/
+func keyChanged(window fyne.Window, client *control.Client, peer string, err error) {
+	logger.Error("public key of the peer changed", "peer", peer, "err", err)
+
+	message := "The public key of " + peer + " changed, someone may be impersonating it.\n" +
+		err.Error() + "\n\nTrust the new key only if you know why it changed."
+	dialog.ShowConfirm("Public key changed", message, func(accept bool) {
+		if !accept {
+			return
+		}
+		known, err := client.AcceptKey(peer)
+		if err != nil {
+			logger.Warn("accepting the key failed", "peer", peer, "err", err)
+			return
+		}
+		logger.Info("new key trusted", "peer", peer, "fingerprint", known.Fingerprint)
+	}, window)
+}
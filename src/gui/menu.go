@@ -2,8 +2,9 @@ package gui
 
 import (
 	"fyne.io/fyne/v2"
+	"log/slog"
 	"os"
-	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/logging"
 )
 
 func MakeMenu() *fyne.MainMenu {
@@ -13,18 +14,31 @@ func MakeMenu() *fyne.MainMenu {
 	fileCategory := fyne.NewMenu("File", quitItem)
 
 	debugCheckbox := fyne.NewMenuItem("Debug", nil)
+	extendedDebug := fyne.NewMenuItem("Extended debug", nil)
+
+	// the two entries select the level of the logs: info, debug or trace
+	setLevel := func() {
+		switch {
+		case extendedDebug.Checked:
+			logging.SetLevel(logging.LevelTrace)
+		case debugCheckbox.Checked:
+			logging.SetLevel(slog.LevelDebug)
+		default:
+			logging.SetLevel(slog.LevelInfo)
+		}
+	}
+
 	debugCheckbox.Action = func() {
-		config.SetDebug(!debugCheckbox.Checked)
 		debugCheckbox.Checked = !debugCheckbox.Checked
+		setLevel()
 	}
-	debugCheckbox.Checked = true
+	debugCheckbox.Checked = logging.Level() <= slog.LevelDebug
 
-	extendedDebug := fyne.NewMenuItem("Extended debug", nil)
 	extendedDebug.Action = func() {
-		config.SetDebugSpam(!extendedDebug.Checked)
 		extendedDebug.Checked = !extendedDebug.Checked
+		setLevel()
 	}
-	debugCheckbox.Checked = true
+	extendedDebug.Checked = logging.Level() <= logging.LevelTrace
 
 	loggingCategory := fyne.NewMenu("Logging", debugCheckbox, extendedDebug)
 
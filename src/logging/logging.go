@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// below debug, for the messages printed for every packet or chunk
+const LevelTrace = slog.LevelDebug - 4
+
+// output formats
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+/*
+Every logger is created from the same root handler, which can be replaced
+at any time: loggers are package variables created before the configuration
+is read, and the output and level change with it (log file, GUI menu...)
+*/
+var (
+	lock   sync.RWMutex
+	root   slog.Handler
+	level            = new(slog.LevelVar)
+	output io.Writer = os.Stderr
+	format           = Text
+)
+
+func init() {
+	root = newHandler()
+}
+
+func newHandler() slog.Handler {
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && attr.Value.Any() == LevelTrace {
+				attr.Value = slog.StringValue("TRACE")
+			}
+			return attr
+		},
+	}
+
+	if format == JSON {
+		return slog.NewJSONHandler(output, options)
+	}
+	return slog.NewTextHandler(output, options)
+}
+
+/*
+Logger of a subsystem (udp, scheduler, rest...), its name is added to
+every message as the "subsystem" attribute
+*/
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&handler{}).With("subsystem", subsystem)
+}
+
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+func Level() slog.Level {
+	return level.Level()
+}
+
+func SetOutput(w io.Writer) {
+	lock.Lock()
+	defer lock.Unlock()
+	output = w
+	root = newHandler()
+}
+
+func SetFormat(f string) error {
+	if f != Text && f != JSON {
+		return errors.New("unknown log format: " + f)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	format = f
+	root = newHandler()
+
+	return nil
+}
+
+/*
+Parses trace, debug, info, warn or error
+*/
+func ParseLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "trace") {
+		return LevelTrace, nil
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return 0, errors.New("unknown log level: " + name)
+	}
+	return l, nil
+}
+
+/*
+Forwards to the current root handler, the attributes and groups added to
+the logger are replayed on it for every message
+*/
+type handler struct {
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) current() slog.Handler {
+	lock.RLock()
+	current := root
+	lock.RUnlock()
+
+	for _, wrap := range h.wrap {
+		current = wrap(current)
+	}
+	return current
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	return h.current().Handle(ctx, record)
+}
+
+func (h *handler) with(wrap func(slog.Handler) slog.Handler) *handler {
+	wraps := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wraps, h.wrap)
+	return &handler{wrap: append(wraps, wrap)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler {
+		return next.WithAttrs(attrs)
+	})
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler {
+		return next.WithGroup(name)
+	})
+}
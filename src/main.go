@@ -1,121 +1,97 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	mrand "math/rand"
-	"net"
+	"os"
+	"protocoles-internet-2023/cli"
 	"protocoles-internet-2023/config"
-	"protocoles-internet-2023/crypto"
-	"protocoles-internet-2023/filestructure"
-	"protocoles-internet-2023/gui"
-	"protocoles-internet-2023/rest"
-	udptypes "protocoles-internet-2023/udp"
-	"time"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/gateway"
+	"protocoles-internet-2023/logging"
+	"protocoles-internet-2023/node"
 )
 
-var ENDPOINT = "https://jch.irif.fr:8443"
+var localNode *node.Node
 
-var scheduler *udptypes.Scheduler
+var logger = logging.Logger("main")
+
+func fatal(msg string, err error) {
+	logger.Error(msg, "err", err)
+	os.Exit(1)
+}
 
 func main() {
 
-	file, err := filestructure.LoadDirectory("test_arborescence")
-	if err != nil {
-		log.Fatal(err)
-	} else if config.Debug {
-		/* passer true à false pour afficher tous les fichiers (descendants bigfiles)
-		 * ATTENTION : risque de faire laguer si l'arborescence est trop grande
-		 */
-		filestructure.PrintFileStructure(file, "", true)
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	daemon := flags.Bool("daemon", false, "run without GUI until SIGINT or SIGTERM")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: "+os.Args[0]+" [options] [command [arguments]]")
+		fmt.Fprintln(os.Stderr, "Without command the GUI is started (or the daemon with -daemon)")
+		fmt.Fprintln(os.Stderr, "Every option can also be set in the -config file or with a "+config.EnvPrefix+" environment variable")
+		fmt.Fprintln(os.Stderr, "Options:")
+		flags.PrintDefaults()
+		cli.Usage()
 	}
 
-	privateKey, publicKey, err := crypto.LoadFromDisk("keys.db")
-	if err != nil {
-		log.Fatal("Could not load cryptographic keys: ", err.Error())
+	cfg, err := config.Load(flags, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(cli.ExitOK)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Configuration: "+err.Error())
+		os.Exit(cli.ExitUsage)
 	}
 
-	socket, err := udptypes.NewUDPSocket()
-	if err != nil {
-		log.Fatal("NewUDPSocket: " + err.Error())
+	if err = cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Configuration: "+err.Error())
+		os.Exit(cli.ExitUsage)
 	}
+	cfg.Apply()
 
-	exported, ok := file.(filestructure.Directory)
-	if !ok {
-		log.Fatal("Root is not a directory")
+	if flags.NArg() > 0 {
+		os.Exit(cli.Run(flags.Args(), cfg))
 	}
 
-	scheduler = udptypes.NewScheduler(*socket, &exported, privateKey, publicKey)
-	go scheduler.Launch(socket)
-
-	window := gui.Init(scheduler, ENDPOINT)
-
-	go func() {
-		for range time.Tick(time.Second * 10) {
-			gui.RefreshPeersNames(ENDPOINT)
-		}
-	}()
-
-	go func() {
-		for range time.Tick(time.Second * 30) {
-			if config.Debug {
-				fmt.Println("Sending Hello to server to maintain association")
-			}
-			HelloToServer()
-		}
-	}()
-
-	if config.Debug {
-		fmt.Println("Sending Hello To Server")
+	if err = cfg.ValidatePaths(); err != nil {
+		fmt.Fprintln(os.Stderr, "Configuration: "+err.Error())
+		os.Exit(cli.ExitUsage)
 	}
-	HelloToServer()
 
-	gui.RefreshPeersNames(ENDPOINT)
-
-	window.ShowAndRun()
-
-}
-
-func HelloToServer() {
-
-	peers, _ := rest.GetPeersNames(ENDPOINT)
-
-	serverIndex := 0
-	for i := 0; i < len(peers); i++ {
-		if peers[i] == "jch.irif.fr" {
-			serverIndex = i
-			break
+	if cfg.LogFile != "" {
+		err := redirectLogs(cfg.LogFile)
+		if err != nil {
+			fatal("opening log file", err)
 		}
 	}
 
-	addresses, err := rest.GetPeerAddresses(ENDPOINT, peers[serverIndex])
+	localNode, err = node.NewNode(cfg)
 	if err != nil {
-		log.Println("Fetching peer addresses: " + err.Error())
-		return
+		fatal("starting node", err)
 	}
 
-	distantAddr, err := net.ResolveUDPAddr("udp", addresses[0])
-	if err != nil {
-		log.Fatal("ResolveUDPAddr " + err.Error())
+	var gw *gateway.Gateway
+	if cfg.Gateway != "" {
+		gw = gateway.NewGateway(localNode.Scheduler, localNode.Directory)
+		go func() {
+			err := gw.ListenAndServe(cfg.Gateway)
+			if err != nil {
+				fatal("gateway", err)
+			}
+		}()
 	}
 
-	//Hello + HelloReply
-	msgBody := udptypes.HelloBody{
-		Extensions: 0,
-		Name:       config.ClientName,
-	}.HelloBodyToBytes()
-
-	msg := udptypes.UDPMessage{
-		Id:         uint32(mrand.Int31()),
-		Type:       udptypes.Hello,
-		Length:     uint16(len(msgBody)),
-		Body:       msgBody,
-		PrivateKey: scheduler.PrivateKey,
+	ctl := control.NewServer(localNode, cfg.DownloadDir)
+	err = ctl.Listen(cfg.ControlSocketPath())
+	if err != nil {
+		fatal("control API", err)
 	}
 
-	_, err = scheduler.SendPacket(msg, distantAddr)
-	if err != nil {
-		fmt.Println("Could not send: ", err.Error())
-		return
+	localNode.Start()
+
+	if *daemon {
+		runDaemon(gw, ctl)
+	} else {
+		runGUI(cfg, gw, ctl)
 	}
 }
@@ -0,0 +1,35 @@
+//go:build !nogui
+
+package main
+
+import (
+	"context"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/gateway"
+	"protocoles-internet-2023/gui"
+	"time"
+)
+
+func runGUI(cfg config.Config, gw *gateway.Gateway, ctl *control.Server) {
+
+	// the GUI drives the node like any other client of the control API
+	client, err := control.Dial(cfg.ControlSocketPath())
+	if err != nil {
+		fatal("control API", err)
+	}
+
+	window := gui.Init(client)
+
+	go func() {
+		for range time.Tick(time.Second * 10) {
+			gui.RefreshPeersNames(client)
+		}
+	}()
+
+	gui.RefreshPeersNames(client)
+
+	window.ShowAndRun()
+
+	ctl.Shutdown(context.Background())
+}
@@ -0,0 +1,15 @@
+//go:build nogui
+
+package main
+
+import (
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/control"
+	"protocoles-internet-2023/gateway"
+)
+
+// built with -tags nogui: no Fyne dependency, the daemon is the only mode
+func runGUI(cfg config.Config, gw *gateway.Gateway, ctl *control.Server) {
+	logger.Info("built without GUI, running as daemon")
+	runDaemon(gw, ctl)
+}
@@ -0,0 +1,207 @@
+package node
+
+import (
+	"net"
+	udptypes "protocoles-internet-2023/udp"
+	"sync"
+	"time"
+)
+
+// interval between two Hello sent to the server while the association is healthy
+const KeepaliveInterval = 30 * time.Second
+
+// delay before the first retry once the server stopped answering, doubled after every failure
+const MinBackoff = 5 * time.Second
+
+// longest delay between two attempts to reach the server
+const MaxBackoff = 5 * time.Minute
+
+/*
+NAT mappings of UDP commonly expire after 30 seconds without traffic, the
+peers we transfer with are sent something before
+*/
+const PeerKeepaliveInterval = 20 * time.Second
+
+// a peer stays active that long after the last datum exchanged with it
+const ActivePeerWindow = 5 * time.Minute
+
+// sessions renewed at once, a peer that does not answer holds one until its Hello times out
+const maxSessionRenewals = 8
+
+/*
+Health of the association with the directory server
+*/
+type Association struct {
+	Server      *net.UDPAddr // nil until the server was reached
+	Healthy     bool         // the last attempt succeeded
+	Registered  bool         // the REST server published our name, key and root since the last failure
+	Failures    int          // attempts failed since the last success
+	LastError   error
+	LastAttempt time.Time
+	LastSuccess time.Time
+	NextAttempt time.Time
+}
+
+/*
+Current health of the association with the server
+*/
+func (node *Node) Association() Association {
+	node.associationLock.Lock()
+	defer node.associationLock.Unlock()
+	association := node.association
+	association.Server = node.Scheduler.Server()
+	return association
+}
+
+// result of an attempt to reach the server, the next one is scheduled after the returned delay
+func (node *Node) recordAssociation(err error) time.Duration {
+	node.associationLock.Lock()
+	defer node.associationLock.Unlock()
+
+	association := &node.association
+	association.LastAttempt = time.Now()
+	association.LastError = err
+
+	if err == nil {
+		if association.Failures != 0 {
+			logger.Info("association with the server restored", "failures", association.Failures)
+		}
+		association.Healthy = true
+		association.Failures = 0
+		association.LastSuccess = association.LastAttempt
+	} else {
+		if association.Healthy {
+			logger.Warn("association with the server lost", "err", err)
+		}
+		association.Healthy = false
+		association.Registered = false
+		association.Failures++
+	}
+
+	delay := backoff(association.Failures)
+	association.NextAttempt = association.LastAttempt.Add(delay)
+	return delay
+}
+
+// delay before the next attempt after that many failures in a row
+func backoff(failures int) time.Duration {
+	if failures == 0 {
+		return KeepaliveInterval
+	}
+
+	delay := MinBackoff
+	for i := 1; i < failures && delay < MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, MaxBackoff)
+}
+
+/*
+Keeps the association with the server alive until Shutdown
+While the server answers, a Hello is sent to the address it answered at.
+Once it does not, its addresses are asked again to the REST server and the
+attempts are spaced out
+*/
+func (node *Node) keepServer(delay time.Duration) {
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			logger.Debug("maintaining association with the server")
+			delay = node.recordAssociation(node.refreshServer())
+		case <-node.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// a Hello to the server, or the whole registration when it stopped answering
+func (node *Node) refreshServer() error {
+	server := node.Scheduler.Server()
+	if server == nil || node.Association().Failures != 0 {
+		return node.registerServer()
+	}
+
+	_, err := node.Scheduler.Hello(server)
+	if err != nil {
+		// the server may have moved, or our session expired on its side
+		logger.Info("server does not answer, resolving its addresses again", "addr", server.String(), "err", err)
+		return node.registerServer()
+	}
+	return nil
+}
+
+/*
+Keeps the NAT mappings towards the active peers open until Shutdown
+A peer is active for ActivePeerWindow after the last datum exchanged with it.
+When neither of us sent anything for PeerKeepaliveInterval it is sent a NoOp,
+which needs no reply, and a Hello once half of its session timed out so that
+the session does not expire in the middle of a transfer
+*/
+func (node *Node) keepPeers() {
+	ticker := time.NewTicker(PeerKeepaliveInterval / 4)
+	defer ticker.Stop()
+
+	pinged := make(map[string]time.Time)
+	for {
+		select {
+		case <-ticker.C:
+			node.pingActivePeers(pinged)
+		case <-node.stop:
+			return
+		}
+	}
+}
+
+/*
+pinged holds when each peer was last sent a keepalive, by address
+The sessions are renewed concurrently, returns once all of them answered
+or timed out
+*/
+func (node *Node) pingActivePeers(pinged map[string]time.Time) {
+	server := node.Scheduler.Server()
+	now := time.Now()
+
+	var renewals sync.WaitGroup
+	defer renewals.Wait()
+	running := make(chan struct{}, maxSessionRenewals)
+
+	for addr, last := range pinged {
+		if now.Sub(last) > ActivePeerWindow {
+			delete(pinged, addr)
+		}
+	}
+
+	for _, peer := range node.Scheduler.Peers.Snapshot() {
+		if peer.Address == nil || (server != nil && peer.Address.String() == server.String()) {
+			continue
+		}
+		if peer.State != udptypes.StateEstablished || time.Since(peer.Transfer) > ActivePeerWindow {
+			continue
+		}
+
+		idle := now.Sub(peer.LastSeen)
+		if idle < PeerKeepaliveInterval || now.Sub(pinged[peer.Address.String()]) < PeerKeepaliveInterval {
+			continue
+		}
+		pinged[peer.Address.String()] = now
+
+		if idle < udptypes.SessionTimeout/2 {
+			logger.Debug("keeping peer alive", "peer", peer.Name, "addr", peer.Address.String(), "idle", idle)
+			node.Scheduler.SendNoOp(peer.Address)
+			continue
+		}
+
+		logger.Debug("renewing session", "peer", peer.Name, "addr", peer.Address.String(), "idle", idle)
+		running <- struct{}{}
+		renewals.Add(1)
+		go func(peer udptypes.PeerSnapshot) {
+			defer renewals.Done()
+			if _, err := node.Scheduler.Hello(peer.Address); err != nil {
+				logger.Info("active peer does not answer", "peer", peer.Name, "addr", peer.Address.String(), "err", err)
+			}
+			<-running
+		}(peer)
+	}
+}
@@ -0,0 +1,303 @@
+package node
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/filestructure"
+	"protocoles-internet-2023/logging"
+	"protocoles-internet-2023/rest"
+	udptypes "protocoles-internet-2023/udp"
+	"slices"
+	"sync"
+)
+
+var logger = logging.Logger("node")
+
+/*
+Everything a peer needs to take part in the network, without any GUI:
+the exported files, the keys, the socket with its scheduler, and the
+association with the directory server
+*/
+type Node struct {
+	Directory   *rest.Client // REST server, where the peers publish their addresses, keys and roots
+	ServerName  string       // name of the directory server among the peers
+	ServerAddrs []string     // UDP addresses of the server, empty to ask the REST server
+	KeyStore    crypto.KeyStore
+	Scheduler   *udptypes.Scheduler
+	Socket      *udptypes.UDPSock
+	access      config.Access
+	stop        chan struct{}
+
+	exportPaths []string
+	exports     filestructure.Provider
+	exportsLock sync.Mutex // the exports are replaced while the control API reads them
+
+	association     Association
+	associationLock sync.Mutex
+}
+
+/*
+Node "constructor"
+Loads the exported tree and the keys and opens the socket, nothing is sent
+before Start
+Without export an empty directory is exported, with several of them each
+one is a directory under the root
+*/
+func NewNode(cfg config.Config) (*Node, error) {
+
+	exports, err := openExports(cfg.PeerName, cfg.Exports)
+	if err != nil {
+		return nil, err
+	}
+
+	exported, err := exports.Load()
+	if err != nil {
+		exports.Close()
+		return nil, errors.New("loading exported files: " + err.Error())
+	}
+	logger.Info("exports loaded", "paths", cfg.Exports, "root", hex.EncodeToString(exported.Hash[:]))
+	if logger.Enabled(context.Background(), logging.LevelTrace) {
+		/* passer true à false pour afficher tous les fichiers (descendants bigfiles)
+		 * ATTENTION : risque de faire laguer si l'arborescence est trop grande
+		 */
+		filestructure.PrintFileStructure(exported, "", true)
+	}
+
+	passphrase, err := cfg.KeyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	privateKey, publicKey, err := crypto.LoadFromDisk(cfg.KeyStore, passphrase)
+	if err != nil {
+		return nil, errors.New("could not load cryptographic keys: " + err.Error())
+	}
+
+	policy, err := crypto.ParsePolicy(cfg.Signatures)
+	if err != nil {
+		return nil, err
+	}
+	encryption, err := crypto.ParseEncryption(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	var knownPeers *crypto.KnownPeers
+	if cfg.KnownPeers != "" {
+		knownPeers, err = crypto.OpenKnownPeers(cfg.KnownPeers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	directory, err := rest.NewClient(cfg.Endpoint, rest.Options{
+		CAFile:   cfg.RESTCA,
+		Pins:     cfg.RESTPins,
+		Timeout:  cfg.RESTTimeout.Duration,
+		CacheTTL: cfg.RESTCache.Duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := udptypes.NewUDPSocket(cfg.ListenAddress)
+	if err != nil {
+		return nil, errors.New("NewUDPSocket: " + err.Error())
+	}
+
+	node := Node{
+		Directory:   directory,
+		ServerName:  cfg.ServerName,
+		ServerAddrs: cfg.ServerAddresses,
+		KeyStore:    crypto.KeyStore{Path: cfg.KeyStore, Passphrase: passphrase},
+		Scheduler:   udptypes.NewScheduler(*socket, &exported, privateKey, publicKey),
+		Socket:      socket,
+		stop:        make(chan struct{}),
+		exportPaths: cfg.Exports,
+		exports:     exports,
+	}
+	node.Scheduler.Name = cfg.PeerName
+	node.Scheduler.Directory = directory
+	node.Scheduler.SignaturePolicy = policy
+	node.Scheduler.KnownPeers = knownPeers
+	node.Scheduler.Encryption = encryption
+	node.Scheduler.Extensions = udptypes.RegisteredExtensions()
+	if encryption == crypto.EncryptionOff {
+		node.Scheduler.Extensions &^= udptypes.ExtensionEncryption
+	}
+	if len(cfg.Access.Allow) != 0 || len(cfg.Access.Deny) != 0 || len(cfg.Access.Shares) != 0 {
+		node.Scheduler.Access = udptypes.NewAccessRules(cfg.Access)
+		node.access = cfg.Access
+		node.checkShares()
+	}
+
+	return &node, nil
+}
+
+// a rule for a share that is not exported is likely a typo
+func (node *Node) checkShares() {
+	exported := make(map[string]bool)
+	for _, child := range node.Scheduler.Exports().Data {
+		if dir, ok := child.(filestructure.Directory); ok {
+			exported[dir.Name] = true
+		} else if big, ok := child.(filestructure.Bigfile); ok {
+			exported[big.Name] = true
+		} else if chunk, ok := child.(filestructure.Chunk); ok {
+			exported[chunk.Name] = true
+		}
+	}
+
+	for share := range node.access.Shares {
+		if !exported[share] {
+			logger.Warn("access rule for a share that is not exported", "share", share)
+		}
+	}
+}
+
+// several exports are gathered in a directory with our name
+func openExports(name string, paths []string) (filestructure.Provider, error) {
+	switch len(paths) {
+	case 0:
+		return &filestructure.MemoryProvider{Name: "empty"}, nil
+	case 1:
+		return filestructure.OpenProvider(paths[0])
+	}
+
+	multi := &filestructure.MultiProvider{Name: name}
+	for _, path := range paths {
+		provider, err := filestructure.OpenProvider(path)
+		if err != nil {
+			return nil, err
+		}
+		multi.Providers = append(multi.Providers, provider)
+	}
+
+	return multi, nil
+}
+
+/*
+Paths of the exported files and archives
+*/
+func (node *Node) ExportPaths() []string {
+	node.exportsLock.Lock()
+	defer node.exportsLock.Unlock()
+	return slices.Clone(node.exportPaths)
+}
+
+/*
+Provider of the exported files, which reads their chunks on demand
+*/
+func (node *Node) Exports() filestructure.Provider {
+	node.exportsLock.Lock()
+	defer node.exportsLock.Unlock()
+	return node.exports
+}
+
+/*
+Replaces the exported files while the node is running
+The new tree is entirely loaded before being swapped, so on error the
+previous exports are still served. The new root is then sent to the server
+*/
+func (node *Node) SetExports(paths []string) error {
+
+	exports, err := openExports(node.Scheduler.Name, paths)
+	if err != nil {
+		return err
+	}
+
+	exported, err := exports.Load()
+	if err != nil {
+		exports.Close()
+		return errors.New("loading exported files: " + err.Error())
+	}
+
+	node.exportsLock.Lock()
+	node.Scheduler.SetExports(&exported)
+	node.exportPaths = slices.Clone(paths)
+	previous := node.exports
+	node.exports = exports
+	node.exportsLock.Unlock()
+
+	// the archives of the previous exports, whose chunks are no longer served
+	if err = previous.Close(); err != nil {
+		logger.Warn("closing previous exports", "err", err)
+	}
+
+	logger.Info("exports changed", "paths", paths, "root", hex.EncodeToString(exported.Hash[:]))
+	node.checkShares()
+
+	// the server publishes the new root once it is sent, see verifyRegistration
+	go node.HelloToServer()
+
+	return nil
+}
+
+/*
+Replaces our key pair by a new one in the store and in use
+The sessions are redone so that the server and the peers get the new key,
+the peers that pinned the previous one will refuse it until they accept it
+*/
+func (node *Node) RotateKeys() (*ecdsa.PublicKey, error) {
+	privateKey, err := node.KeyStore.Rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	node.Scheduler.SetKeys(privateKey, &privateKey.PublicKey)
+	node.Scheduler.ResetSessions()
+
+	if err = node.HelloToServer(); err != nil {
+		return &privateKey.PublicKey, errors.New("the new key is in use but could not be sent to the server: " + err.Error())
+	}
+
+	return &privateKey.PublicKey, nil
+}
+
+/*
+Starts receiving packets and registers with the server, the association
+and the NAT mappings towards the active peers are then maintained until
+Shutdown, see keepServer and keepPeers
+*/
+func (node *Node) Start() {
+	go node.Scheduler.Launch(node.Socket)
+
+	delay := node.recordAssociation(node.registerServer())
+	go node.keepServer(delay)
+	go node.keepPeers()
+}
+
+/*
+Stops the keepalives and closes the socket, which ends the reception loop
+The known peers not saved yet are saved and the exports closed
+*/
+func (node *Node) Shutdown() {
+	close(node.stop)
+
+	err := node.Socket.Socket.Close()
+	if err != nil {
+		logger.Warn("closing socket", "err", err)
+	}
+	if knownPeers := node.Scheduler.KnownPeers; knownPeers != nil {
+		if err = knownPeers.Flush(); err != nil {
+			logger.Warn("saving known peers", "err", err)
+		}
+	}
+	if err = node.Exports().Close(); err != nil {
+		logger.Warn("closing exports", "err", err)
+	}
+}
+
+/*
+Registers with the server, or keeps the association alive once registered,
+see registerServer
+Failures are logged and counted in Association, and returned for the
+callers that care
+*/
+func (node *Node) HelloToServer() error {
+	err := node.registerServer()
+	node.recordAssociation(err)
+	return err
+}
@@ -0,0 +1,161 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/directory"
+	"testing"
+)
+
+/*
+Directory server on loopback, its REST side served by httptest
+Returns the URL of the REST server
+*/
+func newTestNetwork(t *testing.T) string {
+	t.Helper()
+
+	srv, err := directory.NewServer("jch.irif.fr", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(func() { srv.Close() })
+
+	rest := httptest.NewServer(srv)
+	t.Cleanup(rest.Close)
+	return rest.URL
+}
+
+// started node registered with the server of the network, exporting exports
+func newTestNode(t *testing.T, name string, endpoint string, exports string) *Node {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.Endpoint = endpoint
+	cfg.PeerName = name
+	cfg.Exports = []string{exports}
+	cfg.ListenAddress = "127.0.0.1:0"
+	cfg.KeyStore = filepath.Join(t.TempDir(), "keys.db")
+	cfg.KnownPeers = ""
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Start()
+	t.Cleanup(node.Shutdown)
+	return node
+}
+
+// a directory with a small file and one spread over several levels of bigfiles
+func writeExports(t *testing.T) (dir string, big []byte) {
+	t.Helper()
+
+	dir = t.TempDir()
+	big = make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	if err := os.Mkdir(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docs", "big.bin"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, big
+}
+
+func TestTwoNodes(t *testing.T) {
+	endpoint := newTestNetwork(t)
+	exports, big := writeExports(t)
+
+	alice := newTestNode(t, "alice", endpoint, t.TempDir())
+	bob := newTestNode(t, "bob", endpoint, exports)
+	ctx := context.Background()
+
+	// registration
+	for _, node := range []*Node{alice, bob} {
+		if association := node.Association(); !association.Registered {
+			t.Fatalf("%s not registered: %v", node.Scheduler.Name, association.LastError)
+		}
+	}
+	key, err := alice.Directory.Fresh().GetPeerKey(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(bob.Scheduler.PublicKey()) {
+		t.Fatal("the server publishes another key for bob")
+	}
+
+	// alice reaches bob at the addresses bob registered
+	addrs, err := alice.Directory.ResolvePeerAddresses(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := alice.Scheduler.Reach("bob", addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != bob.Socket.Socket.LocalAddr().String() {
+		t.Fatalf("reached %s, bob listens on %s", dest, bob.Socket.Socket.LocalAddr())
+	}
+
+	root, err := alice.Scheduler.FetchRoot(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Hash != bob.Scheduler.RootFor(alice.Socket.Socket.LocalAddr()) {
+		t.Fatal("root fetched is not the one bob announces to alice")
+	}
+
+	hello, err := alice.Scheduler.ResolvePath(root, "hello.txt", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content bytes.Buffer
+	if _, err = alice.Scheduler.CopyFile(&content, hello, dest, 0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "hello\n" {
+		t.Fatalf("hello.txt is %q", content.String())
+	}
+
+	file, err := alice.Scheduler.ResolvePath(root, "docs/big.bin", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content.Reset()
+	n, err := alice.Scheduler.CopyFile(&content, file, dest, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(big)) || !bytes.Equal(content.Bytes(), big) {
+		t.Fatalf("copied %d bytes differing from the %d of the file", n, len(big))
+	}
+
+	content.Reset()
+	if _, err = alice.Scheduler.CopyFile(&content, file, dest, 100000, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content.Bytes(), big[100000:105000]) {
+		t.Fatal("part of the file differs")
+	}
+
+	peer, ok := alice.Scheduler.PeerByAddress(dest)
+	if !ok {
+		t.Fatal("bob not in the peers of alice")
+	}
+	if !peer.KeyProven() || !peer.Encrypted() {
+		t.Fatalf("session with bob: key proven %v, encrypted %v", peer.KeyProven(), peer.Encrypted())
+	}
+}
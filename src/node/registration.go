@@ -0,0 +1,143 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/rest"
+	udptypes "protocoles-internet-2023/udp"
+	"slices"
+)
+
+// the handshake went through but the REST server does not publish what we sent
+var ErrNotRegistered = errors.New("not registered on the server")
+
+/*
+UDP addresses of the server: those of the configuration, or else the ones
+it published on the REST server under its name
+*/
+func (node *Node) serverAddresses() ([]*net.UDPAddr, error) {
+	if len(node.ServerAddrs) == 0 {
+		addrs, err := node.Directory.ResolvePeerAddresses(context.Background(), node.ServerName)
+		if err != nil {
+			return nil, errors.New("addresses of the server " + node.ServerName + ": " + err.Error())
+		}
+		return addrs, nil
+	}
+
+	var addrs []*net.UDPAddr
+	for _, address := range node.ServerAddrs {
+		addr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			logger.Warn("invalid server address", "address", address, "err", err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no server address resolves")
+	}
+	return addrs, nil
+}
+
+/*
+Registers with the server: the whole handshake the first time, a Hello
+while the session lasts, then checks that the REST server publishes our
+name, key and root. Only then are we registered
+*/
+func (node *Node) registerServer() error {
+	addrs, err := node.serverAddresses()
+	if err != nil {
+		logger.Warn("could not find the server", "peer", node.ServerName, "err", err)
+		return err
+	}
+
+	server, err := node.Scheduler.Reach(node.ServerName, addrs)
+	if err != nil {
+		logger.Warn("server unreachable", "peer", node.ServerName, "addresses", len(addrs), "err", err)
+		return err
+	}
+	node.Scheduler.SetServer(server)
+
+	if node.Scheduler.SessionState(server) == udptypes.StateEstablished {
+		_, err = node.Scheduler.Hello(server)
+	} else {
+		_, err = node.Scheduler.Connect(server)
+	}
+	if err != nil {
+		logger.Warn("handshake with server failed", "peer", node.ServerName, "addr", server.String(), "err", err)
+		return err
+	}
+
+	if err = node.verifyRegistration(server); err != nil {
+		logger.Warn("registration not visible on the server", "peer", node.ServerName, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+Checks what the REST server publishes about us against what we sent the
+server. A root that changed since the last Root is sent again once, the
+server may not have it yet
+*/
+func (node *Node) verifyRegistration(server *net.UDPAddr) error {
+	name := node.Scheduler.Name
+	// what the server published a moment ago is what we check, not a cached answer
+	directory := node.Directory.Fresh()
+	ctx := context.Background()
+
+	names, err := directory.GetPeersNames(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(names, name) {
+		return fmt.Errorf("%w: %s is not listed", ErrNotRegistered, name)
+	}
+
+	key, err := directory.GetPeerKey(ctx, name)
+	if errors.Is(err, rest.ErrNoKey) {
+		return fmt.Errorf("%w: no key published for %s", ErrNotRegistered, name)
+	} else if err != nil {
+		return err
+	}
+	if !key.Equal(node.Scheduler.PublicKey()) {
+		return fmt.Errorf("%w: the key published for %s is %s, ours is %s", ErrNotRegistered, name,
+			crypto.Fingerprint(crypto.FormatPublicKey(*key)), crypto.Fingerprint(crypto.FormatPublicKey(*node.Scheduler.PublicKey())))
+	}
+
+	root := node.Scheduler.RootFor(server)
+	published, err := directory.GetPeerRoot(ctx, name)
+	if err != nil && !errors.Is(err, rest.ErrNoRoot) {
+		return err
+	}
+	if published != root {
+		logger.Debug("root published by the server is outdated, sending ours", "published", hex.EncodeToString(published[:]))
+		if _, err = node.Scheduler.GetRoot(server); err != nil {
+			return err
+		}
+		published, err = directory.GetPeerRoot(ctx, name)
+		if errors.Is(err, rest.ErrNoRoot) {
+			return fmt.Errorf("%w: no root published for %s", ErrNotRegistered, name)
+		} else if err != nil {
+			return err
+		}
+	}
+	if published != root {
+		return fmt.Errorf("%w: the root published for %s is %s, ours is %s", ErrNotRegistered, name,
+			hex.EncodeToString(published[:]), hex.EncodeToString(root[:]))
+	}
+
+	node.associationLock.Lock()
+	if !node.association.Registered {
+		logger.Info("registered on the server", "name", name, "root", hex.EncodeToString(root[:]))
+	}
+	node.association.Registered = true
+	node.associationLock.Unlock()
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"protocoles-internet-2023/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the server answered 404
+var ErrNotFound = errors.New("not found")
+
+// the server presented none of the pinned keys
+var ErrPinMismatch = errors.New("certificate of the server matches none of the pinned keys")
+
+// largest body read, the answers of the server are a few lines
+const maxResponseSize = 1 << 20
+
+/*
+Answer of the server with a status other than 200, 204 or 304
+A 404 matches ErrNotFound
+*/
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (err *StatusError) Error() string {
+	return "GET " + err.URL + ": " + err.Status
+}
+
+func (err *StatusError) Is(target error) bool {
+	return target == ErrNotFound && err.StatusCode == http.StatusNotFound
+}
+
+/*
+Settings of a Client, the zero value verifies the server against the
+system roots and keeps nothing
+*/
+type Options struct {
+	CAFile   string        // PEM certificates trusted instead of the system roots
+	Pins     []string      // hex SHA-256 of public keys (SubjectPublicKeyInfo), the server must present one
+	Timeout  time.Duration // of a whole request, config.RESTTimeout when 0
+	CacheTTL time.Duration // answers younger than that are not asked again
+}
+
+/*
+Client of the REST server, safe for concurrent use
+Answers are kept for CacheTTL, then asked again with the ETag the server
+gave, if any, so that an unchanged one is not sent again
+*/
+type Client struct {
+	BaseURL string
+	http    *http.Client
+	ttl     time.Duration
+	cache   *responseCache
+}
+
+type cachedResponse struct {
+	body    []byte
+	etag    string
+	fetched time.Time
+}
+
+type responseCache struct {
+	lock    sync.Mutex
+	entries map[string]cachedResponse
+}
+
+func (cache *responseCache) get(url string) (cachedResponse, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, ok := cache.entries[url]
+	return entry, ok
+}
+
+func (cache *responseCache) put(url string, entry cachedResponse) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.entries[url] = entry
+}
+
+func NewClient(baseURL string, options Options) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("REST server must be an http(s) URL, got \"" + baseURL + "\"")
+	}
+
+	tlsConfig, err := options.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = config.RESTTimeout
+	}
+
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Transport: transport, Timeout: timeout},
+		ttl:     options.CacheTTL,
+		cache:   &responseCache{entries: make(map[string]cachedResponse)},
+	}, nil
+}
+
+func (options Options) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if options.CAFile != "" {
+		certificates, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, errors.New("reading the certificates of the REST server: " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(certificates) {
+			return nil, errors.New("no PEM certificate in " + options.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(options.Pins) != 0 {
+		pins := make(map[string]bool)
+		for _, pin := range options.Pins {
+			pins[strings.ToLower(pin)] = true
+		}
+
+		// on top of the usual verification, which still applies
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, certificate := range state.PeerCertificates {
+				sum := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+				if pins[hex.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+/*
+Same client, sharing the cache, that asks the server every time
+An unchanged answer is still not sent again when the server gives ETags
+*/
+func (client *Client) Fresh() *Client {
+	fresh := *client
+	fresh.ttl = 0
+	return &fresh
+}
+
+/*
+Body of the answer to GET path, relative to the base URL
+Statuses other than 200, 204 and 304 are a *StatusError
+*/
+func (client *Client) Get(ctx context.Context, path string) ([]byte, error) {
+	url := client.BaseURL + path
+
+	cached, ok := client.cache.get(url)
+	if ok && time.Since(cached.fetched) < client.ttl {
+		return cached.body, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ok && cached.etag != "" {
+		request.Header.Set("If-None-Match", cached.etag)
+	}
+
+	start := time.Now()
+	response, err := client.http.Do(request)
+	if err != nil {
+		logger.Warn("GET failed", "url", url, "err", err)
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		if ok && cached.etag != "" {
+			logger.Debug("GET", "url", url, "status", response.StatusCode, "duration", time.Since(start))
+			cached.fetched = time.Now()
+			client.cache.put(url, cached)
+			return cached.body, nil
+		}
+	case http.StatusOK, http.StatusNoContent:
+		body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize+1))
+		if err != nil {
+			logger.Warn("reading response failed", "url", url, "status", response.StatusCode, "err", err)
+			return nil, fmt.Errorf("GET %s: %w", url, err)
+		}
+		if len(body) > maxResponseSize {
+			return nil, errors.New("GET " + url + ": answer larger than " + fmt.Sprint(maxResponseSize) + " bytes")
+		}
+
+		logger.Debug("GET", "url", url, "status", response.StatusCode, "size", len(body), "duration", time.Since(start))
+		client.cache.put(url, cachedResponse{body: body, etag: response.Header.Get("ETag"), fetched: time.Now()})
+		return body, nil
+	}
+
+	// drained so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseSize))
+	logger.Warn("GET refused", "url", url, "status", response.StatusCode)
+	return nil, &StatusError{URL: url, StatusCode: response.StatusCode, Status: response.Status}
+}
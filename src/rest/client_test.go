@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"protocoles-internet-2023/crypto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, options Options) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestStatusError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/peers/unknown/key":
+			http.NotFound(w, r)
+		case "/peers/broken/key":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	}, Options{})
+	ctx := context.Background()
+
+	_, err := client.GetPeerKey(ctx, "unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("404: got %v, want ErrNotFound", err)
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("404: got %v, want a *StatusError", err)
+	}
+
+	_, err = client.GetPeerKey(ctx, "broken")
+	if errors.Is(err, ErrNotFound) {
+		t.Fatal("500 reported as not found")
+	}
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("500: got %v, want a *StatusError", err)
+	}
+}
+
+func TestNoContent(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, Options{})
+	ctx := context.Background()
+
+	if _, err := client.GetPeerKey(ctx, "alice"); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("key: got %v, want ErrNoKey", err)
+	}
+	if _, err := client.GetPeerRoot(ctx, "alice"); !errors.Is(err, ErrNoRoot) {
+		t.Fatalf("root: got %v, want ErrNoRoot", err)
+	}
+}
+
+func TestGetPeerKey(t *testing.T) {
+	_, publicKey, err := crypto.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/peers/alice/key":
+			w.Write(crypto.FormatPublicKey(*publicKey))
+		case "/peers/bob/key":
+			w.Write(make([]byte, 64))
+		}
+	}, Options{})
+	ctx := context.Background()
+
+	key, err := client.GetPeerKey(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(publicKey) {
+		t.Fatal("key changed")
+	}
+
+	if _, err = client.GetPeerKey(ctx, "bob"); err == nil {
+		t.Fatal("key outside of the curve accepted")
+	}
+}
+
+func TestCacheAndETag(t *testing.T) {
+	var requests, notModified atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("alice\nbob\n"))
+	}, Options{CacheTTL: time.Hour})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		names, err := client.GetPeersNames(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(names, ",") != "alice,bob" {
+			t.Fatalf("got %v", names)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("%d requests, the second answer should come from the cache", requests.Load())
+	}
+
+	// asked again, the unchanged answer is not sent again
+	names, err := client.Fresh().GetPeersNames(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || requests.Load() != 2 || notModified.Load() != 1 {
+		t.Fatalf("got %v after %d requests, %d not modified", names, requests.Load(), notModified.Load())
+	}
+}
+
+func TestPins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("alice\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	certificate := server.Certificate()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	ctx := context.Background()
+
+	pinned, err := NewClient(server.URL, Options{CAFile: caFile, Pins: []string{hex.EncodeToString(sum[:])}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pinned.GetPeersNames(ctx); err != nil {
+		t.Fatalf("pinned key refused: %v", err)
+	}
+
+	other, err := NewClient(server.URL, Options{CAFile: caFile, Pins: []string{strings.Repeat("00", 32)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.GetPeersNames(ctx); !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("got %v, want ErrPinMismatch", err)
+	}
+
+	untrusted, err := NewClient(server.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = untrusted.GetPeersNames(ctx); err == nil {
+		t.Fatal("certificate of an unknown authority accepted")
+	}
+}
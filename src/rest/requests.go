@@ -1,14 +1,25 @@
 package rest
 
 import (
-	"crypto/tls"
+	"context"
+	"crypto/ecdsa"
 	"errors"
-	"io"
-	"net/http"
+	"net"
+	"net/url"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/logging"
+	"strconv"
 	"strings"
-	"time"
 )
 
+var logger = logging.Logger("rest")
+
+// the peer is registered but did not send the server its key
+var ErrNoKey = errors.New("no key published")
+
+// the peer is registered but did not send the server its root
+var ErrNoRoot = errors.New("no root published")
+
 func trimEmptyLine(slice []string) []string {
 	var newSlice []string
 	for _, val := range slice {
@@ -20,61 +31,92 @@ func trimEmptyLine(slice []string) []string {
 	return newSlice
 }
 
-func SendGet(url string) (string, error) {
-
-	transport := &*http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   50 * time.Second,
-	}
-
-	res, err := client.Get(url)
-	if err != nil {
-		return "", errors.New("error sending get: " + err.Error())
-	}
+func peerPath(peerName string, resource string) string {
+	return "/peers/" + url.PathEscape(peerName) + "/" + resource
+}
 
-	body, err := io.ReadAll(res.Body)
+func (client *Client) GetPeersNames(ctx context.Context) ([]string, error) {
+	res, err := client.Get(ctx, "/peers/")
 	if err != nil {
-		return "", errors.New("error parsing response body: " + err.Error())
+		return nil, err
 	}
 
-	return string(body), nil
+	return trimEmptyLine(strings.Split(string(res), "\n")), nil
 }
 
-func GetPeersNames(endpoint string) ([]string, error) {
-
-	res, err := SendGet(endpoint + "/peers/")
+func (client *Client) GetPeerAddresses(ctx context.Context, peerName string) ([]string, error) {
+	res, err := client.Get(ctx, peerPath(peerName, "addresses"))
 	if err != nil {
 		return nil, err
 	}
 
-	return trimEmptyLine(strings.Split(res, "\n")), nil
+	return strings.Split(string(res), "\n"), nil
 }
 
-func GetPeerAddresses(endpoint string, peerName string) ([]string, error) {
-	res, err := SendGet(endpoint + "/peers/" + peerName + "/addresses")
+/*
+Public key the peer registered, ErrNoKey when it has none
+The key is binary, the body is not split in lines
+*/
+func (client *Client) GetPeerKey(ctx context.Context, peerName string) (*ecdsa.PublicKey, error) {
+	res, err := client.Get(ctx, peerPath(peerName, "key"))
 	if err != nil {
 		return nil, err
 	}
 
-	return strings.Split(res, "\n"), nil
+	if len(res) == 0 {
+		return nil, ErrNoKey
+	}
+	if err = crypto.ValidatePublicKey(res); err != nil {
+		return nil, errors.New("key of " + peerName + " on the server: " + err.Error())
+	}
+
+	key := crypto.ParsePublicKey(res)
+	return &key, nil
 }
 
-func GetPeerKey(endpoint string, peerName string) ([]string, error) {
-	res, err := SendGet(endpoint + "/peers/" + peerName + "/key")
+/*
+Hash of the root the peer last sent the server, ErrNoRoot when it sent none
+*/
+func (client *Client) GetPeerRoot(ctx context.Context, peerName string) ([32]byte, error) {
+	var root [32]byte
+
+	res, err := client.Get(ctx, peerPath(peerName, "root"))
 	if err != nil {
-		return nil, err
+		return root, err
 	}
 
-	return strings.Split(res, "\n"), nil
+	if len(res) == 0 {
+		return root, ErrNoRoot
+	}
+	if len(res) != len(root) {
+		return root, errors.New("root of " + peerName + " on the server is " + strconv.Itoa(len(res)) + " bytes long")
+	}
+
+	copy(root[:], res)
+	return root, nil
 }
 
-func GetPeerRoot(endpoint string, peerName string) ([]string, error) {
-	res, err := SendGet(endpoint + "/peers/" + peerName + "/root")
+/*
+Every address of the peer that resolves, in the order of the server
+*/
+func (client *Client) ResolvePeerAddresses(ctx context.Context, peerName string) ([]*net.UDPAddr, error) {
+	addresses, err := client.GetPeerAddresses(ctx, peerName)
 	if err != nil {
 		return nil, err
 	}
 
-	return trimEmptyLine(strings.Split(res, "\n")), nil
+	var resolved []*net.UDPAddr
+	for _, address := range trimEmptyLine(addresses) {
+		addr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			logger.Warn("invalid peer address", "peer", peerName, "address", address, "err", err)
+			continue
+		}
+		resolved = append(resolved, addr)
+	}
+
+	if len(resolved) == 0 {
+		return nil, errors.New("peer has no address")
+	}
+	return resolved, nil
 }
@@ -0,0 +1,137 @@
+package udptypes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/filestructure"
+	"sort"
+	"strings"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+// root of a peer that exports nothing
+var emptyRoot = sha256.Sum256([]byte(""))
+
+/*
+Access rules of config.Access, by key fingerprint
+*/
+type AccessRules struct {
+	allow  map[string]bool
+	deny   map[string]bool
+	shares map[string]map[string]bool
+}
+
+func toSet(fingerprints []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, fingerprint := range fingerprints {
+		set[strings.ToLower(fingerprint)] = true
+	}
+	return set
+}
+
+func NewAccessRules(access config.Access) *AccessRules {
+	rules := AccessRules{
+		allow:  toSet(access.Allow),
+		deny:   toSet(access.Deny),
+		shares: make(map[string]map[string]bool),
+	}
+	for share, peers := range access.Shares {
+		rules.shares[share] = toSet(peers)
+	}
+	return &rules
+}
+
+/*
+Whether the peer with this key may read anything
+*/
+func (rules *AccessRules) Allowed(fingerprint string) bool {
+	if rules.deny[fingerprint] {
+		return false
+	}
+	return len(rules.allow) == 0 || rules.allow[fingerprint]
+}
+
+/*
+Whether the share appears in the tree of the peer with this key
+*/
+func (rules *AccessRules) Visible(fingerprint string, share string) bool {
+	peers, restricted := rules.shares[share]
+	return !restricted || peers[fingerprint]
+}
+
+/*
+Tree the peer may read: the exports without the shares hidden from it
+A peer that did not prove its key is treated as one without key
+The views are kept until the exports change, peers with the same
+visible shares share the same view
+*/
+func (sched *Scheduler) exportsFor(peer *PeerInfo) (*filestructure.Directory, error) {
+	exports := sched.Exports()
+	if sched.Access == nil {
+		return exports, nil
+	}
+
+	// anyone can send the key of a colleague, it only counts once the peer
+	// showed it holds the private key. A denied key is refused either way
+	claimed := crypto.Fingerprint(peer.PublicKey())
+	if sched.Access.deny[claimed] {
+		return nil, ErrAccessDenied
+	}
+	fingerprint := crypto.Fingerprint(nil)
+	if peer.KeyProven() {
+		fingerprint = claimed
+	}
+	if !sched.Access.Allowed(fingerprint) {
+		return nil, ErrAccessDenied
+	}
+
+	hidden := make(map[string]bool)
+	for share := range sched.Access.shares {
+		if !sched.Access.Visible(fingerprint, share) {
+			hidden[share] = true
+		}
+	}
+	if len(hidden) == 0 {
+		return exports, nil
+	}
+
+	names := make([]string, 0, len(hidden))
+	for share := range hidden {
+		names = append(names, share)
+	}
+	sort.Strings(names)
+	key := hex.EncodeToString(exports.Hash[:]) + "/" + strings.Join(names, "/")
+
+	sched.viewsLock.Lock()
+	defer sched.viewsLock.Unlock()
+
+	view, ok := sched.views[key]
+	if !ok {
+		filtered := exports.Without(hidden)
+		view = &filtered
+		sched.views[key] = view
+	}
+	return view, nil
+}
+
+/*
+Root we announce to the peer at this address, the hash of nothing when it
+may not read our exports
+*/
+func (sched *Scheduler) RootFor(dest net.Addr) [32]byte {
+	peer, ok := sched.PeerByAddress(dest)
+	if !ok {
+		peer = &PeerInfo{}
+	}
+
+	view, err := sched.exportsFor(peer)
+	if err != nil {
+		return emptyRoot
+	}
+	return view.Hash
+}
@@ -0,0 +1,129 @@
+package udptypes
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"net"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/filestructure"
+	"slices"
+	"testing"
+)
+
+// scheduler exporting a public and a private share
+func newAccessScheduler(t *testing.T, access config.Access) *Scheduler {
+	t.Helper()
+
+	exports, err := (&filestructure.MemoryProvider{
+		Name: "exports",
+		Files: map[string][]byte{
+			"public/a.txt":  []byte("for everyone"),
+			"private/b.txt": []byte("for alice"),
+		},
+	}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, publicKey, err := crypto.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched := NewScheduler(UDPSock{}, &exports, privateKey, publicKey)
+	sched.Access = NewAccessRules(access)
+	return sched
+}
+
+func shareNames(dir *filestructure.Directory) []string {
+	var names []string
+	for _, child := range dir.Data {
+		if share, ok := child.(filestructure.Directory); ok {
+			names = append(names, share.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	privateKey, publicKey, err := crypto.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, crypto.FormatPublicKey(*publicKey)
+}
+
+func TestExportsForUnprovenKey(t *testing.T) {
+	_, alice := newKey(t)
+	sched := newAccessScheduler(t, config.Access{
+		Shares: map[string][]string{"private": {crypto.Fingerprint(alice)}},
+	})
+
+	// anyone can send the key of alice
+	peer := &PeerInfo{Name: "alice"}
+	sched.setPublicKey(peer, alice)
+
+	view, err := sched.exportsFor(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := shareNames(view); !slices.Equal(names, []string{"public"}) {
+		t.Fatalf("unproven key sees %v, want only the public share", names)
+	}
+
+	// proving another key proves nothing, see TestReplayProvesNothing for how keys are proven
+	_, impostor := newKey(t)
+	if peer.proveKey(impostor) || peer.KeyProven() {
+		t.Fatal("key proven by another key")
+	}
+
+	if !peer.proveKey(alice) {
+		t.Fatal("key not proven")
+	}
+	view, err = sched.exportsFor(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := shareNames(view); !slices.Equal(names, []string{"private", "public"}) {
+		t.Fatalf("proven key sees %v, want both shares", names)
+	}
+
+	// another key must be proven again
+	_, other := newKey(t)
+	sched.setPublicKey(peer, other)
+	if peer.KeyProven() {
+		t.Fatal("new key proven by the previous one")
+	}
+}
+
+func TestExportsForAllowDeny(t *testing.T) {
+	_, alice := newKey(t)
+	_, mallory := newKey(t)
+	sched := newAccessScheduler(t, config.Access{
+		Allow: []string{crypto.Fingerprint(alice), crypto.Fingerprint(mallory)},
+		Deny:  []string{crypto.Fingerprint(mallory)},
+	})
+
+	peer := &PeerInfo{Name: "alice"}
+	sched.setPublicKey(peer, alice)
+	if _, err := sched.exportsFor(peer); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("unproven key in allow: got %v, want ErrAccessDenied", err)
+	}
+	peer.proveKey(alice)
+	if _, err := sched.exportsFor(peer); err != nil {
+		t.Fatalf("proven key in allow: %v", err)
+	}
+
+	// a denied key is refused even unproven
+	denied := &PeerInfo{Name: "mallory"}
+	sched.setPublicKey(denied, mallory)
+	if _, err := sched.exportsFor(denied); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("denied key: got %v, want ErrAccessDenied", err)
+	}
+
+	if root := sched.RootFor(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8443}); root != emptyRoot {
+		t.Fatal("unknown peer gets a root although allow is set")
+	}
+}
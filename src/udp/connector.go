@@ -0,0 +1,139 @@
+package udptypes
+
+import (
+	"net"
+	"protocoles-internet-2023/config"
+	"time"
+)
+
+// delay before trying the next address of a peer, as in Happy Eyeballs (RFC 8305)
+const AttemptDelay = 250 * time.Millisecond
+
+/*
+Address of the peer to talk to, among those it registered on the server
+The address that answered last time is kept while its session lasts and
+the peer answers our requests, otherwise a Hello is sent to each address in turn, without waiting for the
+previous ones to time out, and the first to answer is used
+*/
+func (sched *Scheduler) Reach(name string, addrs []*net.UDPAddr) (*net.UDPAddr, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoResponse
+	}
+
+	ordered := interleave(addrs)
+	if known, ok := sched.PeerAddress(name); ok {
+		for i, addr := range ordered {
+			if addr.String() != known.String() {
+				continue
+			}
+			peer, _ := sched.PeerByAddress(addr)
+			if state := sched.SessionState(addr); state != StateUnknown && state != StateExpired && peer.Failures() == 0 {
+				return addr, nil
+			}
+			// tried first, it is the most likely to answer
+			ordered = append([]*net.UDPAddr{addr}, append(ordered[:i:i], ordered[i+1:]...)...)
+			break
+		}
+	}
+
+	// the replies are checked against it as they arrive
+	sched.prefetchDirectoryKey(name)
+
+	if len(ordered) == 1 {
+		return ordered[0], nil
+	}
+
+	addr, err := sched.race(ordered)
+	if err == ErrNoResponse {
+		// every address may be behind the NAT of the peer
+		traversal := false
+		for _, addr := range ordered {
+			traversal = sched.requestTraversal(addr) || traversal
+		}
+		if traversal {
+			addr, err = sched.race(ordered)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if peer, ok := sched.PeerByAddress(addr); ok {
+		peer.setAddress(addr)
+	}
+	schedLogger.Debug("peer reached", sched.peerAttrs(addr), "addresses", len(addrs))
+
+	return addr, nil
+}
+
+/*
+IPv6 and IPv4 addresses alternated, starting with IPv6
+*/
+func interleave(addrs []*net.UDPAddr) []*net.UDPAddr {
+	var v6, v4 []*net.UDPAddr
+	for _, addr := range addrs {
+		if addr.IP.To4() == nil {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+
+	ordered := make([]*net.UDPAddr, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
+
+/*
+Sends the same Hello to each address, AttemptDelay apart, and returns the
+first address whose reply arrives. Every round waits for the replies longer
+*/
+func (sched *Scheduler) race(addrs []*net.UDPAddr) (*net.UDPAddr, error) {
+	hello := sched.helloMessage()
+	replies, done := sched.replies.await(hello.Id, addrs...)
+	defer done()
+
+	timeout := config.RequestTimeout
+	for round := 0; round < config.RequestRetries; round++ {
+		for i, addr := range addrs {
+			if err := sched.Socket.SendPacket(hello, addr); err != nil {
+				// e.g. IPv6 address without IPv6 connectivity
+				schedLogger.Debug("sending failed", sched.peerAttrs(addr), messageAttrs(hello), "err", err)
+				continue
+			}
+
+			wait := AttemptDelay
+			if i == len(addrs)-1 {
+				wait = timeout
+			}
+			if entry, ok := awaitReply(replies, wait); ok {
+				for _, addr := range addrs {
+					if addr.String() == entry.From.String() {
+						return addr, checkReply(entry, HelloReply, "Hello")
+					}
+				}
+			}
+		}
+		timeout *= 2
+	}
+
+	schedLogger.Info("no address answered", "addresses", len(addrs), "attempts", config.RequestRetries)
+	return nil, ErrNoResponse
+}
+
+// first reply to the request for that long
+func awaitReply(replies <-chan SchedulerEntry, wait time.Duration) (SchedulerEntry, bool) {
+	select {
+	case entry := <-replies:
+		return entry, true
+	case <-time.After(wait):
+		return SchedulerEntry{}, false
+	}
+}
@@ -0,0 +1,111 @@
+package udptypes
+
+import (
+	"errors"
+	"net"
+	"protocoles-internet-2023/crypto"
+)
+
+// bodies of GetDatum and Datum are encrypted, see crypto.Transport
+var ExtensionEncryption = RegisterExtension(Extension{Name: "encryption", Bit: 0})
+
+var ErrEncryptionRequired = errors.New("encryption required")
+
+// types whose body is encrypted once the extension is negotiated
+func encryptedType(msgType uint8) bool {
+	return msgType == GetDatum || msgType == Datum
+}
+
+/*
+Derives the key shared with the peer once its public key is known, if both
+of us advertised the extension. Otherwise we talk in clear
+*/
+func (sched *Scheduler) newTransport(peer *PeerInfo, publicKey []byte) *crypto.Transport {
+	if peer.Negotiated&ExtensionEncryption == 0 || len(publicKey) == 0 {
+		return nil
+	}
+
+	transport, err := crypto.NewTransport(sched.privateKey(), publicKey)
+	if err != nil {
+		schedLogger.Warn("could not derive the encryption key, talking in clear", "peer", peer.Name, "err", err)
+		return nil
+	}
+	schedLogger.Debug("encryption negotiated", "peer", peer.Name)
+	return transport
+}
+
+// id and type of the message, authenticated with the body
+func sealedHeader(msg UDPMessage) []byte {
+	return []byte{byte(msg.Id >> 24), byte(msg.Id >> 16), byte(msg.Id >> 8), byte(msg.Id), msg.Type}
+}
+
+/*
+Encrypts the body of a message before it is sent, when the extension was
+negotiated with the peer. Without it, the policy may forbid sending in clear
+*/
+func (sched *Scheduler) seal(msg *UDPMessage, dest net.Addr) error {
+	if !encryptedType(msg.Type) {
+		return nil
+	}
+
+	var transport *crypto.Transport
+	if peer, ok := sched.PeerByAddress(dest); ok {
+		transport = peer.encryption()
+	}
+	if transport == nil {
+		if sched.Encryption == crypto.EncryptionRequired {
+			return ErrEncryptionRequired
+		}
+		return nil
+	}
+
+	body, err := transport.Seal(sealedHeader(*msg), msg.Body)
+	if err != nil {
+		return err
+	}
+	msg.Body = body
+	msg.Length = uint16(len(body))
+
+	return nil
+}
+
+/*
+Decrypts the body of a received message, the signature stays checked over
+the encrypted bytes in Raw
+*/
+func (sched *Scheduler) open(peer *PeerInfo, received *UDPMessage) error {
+	if !encryptedType(received.Type) {
+		return nil
+	}
+
+	transport := peer.encryption()
+	if transport == nil {
+		if sched.Encryption == crypto.EncryptionRequired {
+			return ErrEncryptionRequired
+		}
+		return nil
+	}
+
+	body, err := transport.Open(sealedHeader(*received), received.Body)
+	if err != nil {
+		return err
+	}
+	received.Body = body
+	received.Length = uint16(len(body))
+
+	return nil
+}
+
+/*
+Whether the bodies exchanged with the peer are encrypted
+*/
+func (peer *PeerInfo) Encrypted() bool {
+	return peer.encryption() != nil
+}
+
+// nil unless the bodies are encrypted
+func (peer *PeerInfo) encryption() *crypto.Transport {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.transport
+}
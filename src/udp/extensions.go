@@ -0,0 +1,103 @@
+package udptypes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+var ErrExtensionNotNegotiated = errors.New("extension not negotiated with the peer")
+
+/*
+A protocol extension, advertised with its bit in the Extensions field of
+Hello and HelloReply
+An extension is only used with the peers that advertised it as well, its
+message types are neither sent to nor accepted from the others
+*/
+type Extension struct {
+	Name  string
+	Bit   uint8   // 0 to 31
+	Types []uint8 // message types added by the extension
+}
+
+var registry = make(map[uint8]Extension)
+
+// extension adding each message type, by bit mask
+var extensionTypes = make(map[uint8]int32)
+
+/*
+Declares an extension and returns its mask, two extensions can not share a
+bit or a message type
+*/
+func RegisterExtension(extension Extension) int32 {
+	if extension.Bit > 31 {
+		panic("extension " + extension.Name + ": bit must be between 0 and 31")
+	}
+	if other, ok := registry[extension.Bit]; ok {
+		panic("extensions " + other.Name + " and " + extension.Name + " use bit " + strconv.Itoa(int(extension.Bit)))
+	}
+
+	mask := int32(1) << extension.Bit
+	for _, msgType := range extension.Types {
+		if _, ok := extensionTypes[msgType]; ok {
+			panic("extension " + extension.Name + ": message type " + strconv.Itoa(int(msgType)) + " already used")
+		}
+		extensionTypes[msgType] = mask
+	}
+	registry[extension.Bit] = extension
+
+	return mask
+}
+
+/*
+Every registered extension, what we advertise unless some are disabled
+*/
+func RegisteredExtensions() int32 {
+	var set int32
+	for bit := range registry {
+		set |= int32(1) << bit
+	}
+	return set
+}
+
+/*
+Names of the extensions of a set, unknown bits are given by number
+*/
+func ExtensionNames(set int32) []string {
+	var names []string
+	for bit := uint8(0); bit < 32; bit++ {
+		if set&(int32(1)<<bit) == 0 {
+			continue
+		}
+		if extension, ok := registry[bit]; ok {
+			names = append(names, extension.Name)
+		} else {
+			names = append(names, "bit "+strconv.Itoa(int(bit)))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Refuses a message type of an extension the peer did not negotiate
+*/
+func (sched *Scheduler) checkExtension(msgType uint8, addr net.Addr) error {
+	mask, ok := extensionTypes[msgType]
+	if !ok {
+		return nil
+	}
+
+	peer, ok := sched.PeerByAddress(addr)
+	if !ok || peer.Negotiated&mask == 0 {
+		for bit, extension := range registry {
+			if int32(1)<<bit == mask {
+				return fmt.Errorf("%s: %w", extension.Name, ErrExtensionNotNegotiated)
+			}
+		}
+		return ErrExtensionNotNegotiated
+	}
+	return nil
+}
@@ -1,18 +1,36 @@
 package udptypes
 
 import (
-	"fmt"
+	"errors"
 	"protocoles-internet-2023/crypto"
 )
 
-func (bytes UDPMessageBytes) BytesToMessage() UDPMessage {
+var ErrShortDatagram = errors.New("datagram shorter than a header")
+var ErrTruncatedBody = errors.New("datagram shorter than its body length")
+var ErrMalformedBody = errors.New("malformed message body")
 
-	udpMsg := UDPMessage{}
+/*
+Parses a received datagram, which is kept in Raw to check the signature
+over the exact bytes that arrived
+*/
+func (bytes UDPMessageBytes) BytesToMessage() (UDPMessage, error) {
+
+	if len(bytes) < 7 {
+		return UDPMessage{}, ErrShortDatagram
+	}
+
+	udpMsg := UDPMessage{
+		Raw: bytes,
+	}
 
 	udpMsg.Id += uint32(bytes[0])*(1<<24) + (uint32(bytes[1]) * (1 << 16)) + (uint32(bytes[2]) * (1 << 8)) + uint32(bytes[3])
 	udpMsg.Type = bytes[4]
 	udpMsg.Length = uint16(int(bytes[5])*256 + int(bytes[6]))
 
+	if len(bytes) < 7+int(udpMsg.Length) {
+		return UDPMessage{}, ErrTruncatedBody
+	}
+
 	udpMsg.Body = make([]byte, udpMsg.Length)
 	for i := 0; i < int(udpMsg.Length); i++ {
 		udpMsg.Body[i] = bytes[i+7]
@@ -24,7 +42,40 @@ func (bytes UDPMessageBytes) BytesToMessage() UDPMessage {
 
 	//udpMsg.Body = bytes[7:]
 
-	return udpMsg
+	return udpMsg, nil
+}
+
+/*
+Checks the length of the bodies we parse, so that a short one is refused
+instead of being read out of bounds
+*/
+func (udpMsg UDPMessage) CheckBody() error {
+	length := len(udpMsg.Body)
+
+	switch udpMsg.Type {
+	case Hello, HelloReply:
+		if length < 4 {
+			return ErrMalformedBody
+		}
+	case PublicKey, PublicKeyReply:
+		if length != 0 && length != 64 {
+			return ErrMalformedBody
+		}
+	case Root, RootReply, GetDatum:
+		if length != 32 {
+			return ErrMalformedBody
+		}
+	case Datum:
+		if length < 32 {
+			return ErrMalformedBody
+		}
+	case NatTraversalRequest, NatTraversal:
+		if length != 6 && length != 18 {
+			return ErrMalformedBody
+		}
+	}
+
+	return nil
 }
 
 func (udpMsg UDPMessage) MessageToBytes() UDPMessageBytes {
@@ -47,7 +98,7 @@ func (udpMsg UDPMessage) MessageToBytes() UDPMessageBytes {
 	if udpMsg.PrivateKey != nil {
 		signature, err := crypto.GenerateSignature(bytes, udpMsg.PrivateKey)
 		if err != nil {
-			fmt.Println("signature of message failed")
+			logger.Error("signing failed, message sent unsigned", messageAttrs(udpMsg), "err", err)
 		}
 		bytes = append(bytes, signature...)
 	}
@@ -0,0 +1,131 @@
+package udptypes
+
+import (
+	"bytes"
+	"errors"
+	"protocoles-internet-2023/crypto"
+	"testing"
+)
+
+func TestBytesToMessage(t *testing.T) {
+	sent := UDPMessage{
+		Id:     0x01020304,
+		Type:   GetDatum,
+		Length: 32,
+		Body:   bytes.Repeat([]byte{7}, 32),
+	}
+
+	datagram := sent.MessageToBytes()
+	if len(datagram) != 7+32 {
+		t.Fatalf("datagram of %d bytes, want %d", len(datagram), 7+32)
+	}
+
+	received, err := datagram.BytesToMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Id != sent.Id || received.Type != sent.Type || received.Length != sent.Length {
+		t.Fatalf("header %d/%d/%d, sent %d/%d/%d", received.Id, received.Type, received.Length, sent.Id, sent.Type, sent.Length)
+	}
+	if !bytes.Equal(received.Body, sent.Body) {
+		t.Fatal("body changed")
+	}
+	if len(received.Signature) != 0 {
+		t.Fatal("signature found in an unsigned message")
+	}
+	if err = received.CheckBody(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBytesToMessageSigned(t *testing.T) {
+	privateKey, publicKey, err := crypto.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := HelloBody{Name: "alice"}.HelloBodyToBytes()
+	datagram := UDPMessage{
+		Id:         42,
+		Type:       Hello,
+		Length:     uint16(len(body)),
+		Body:       body,
+		PrivateKey: privateKey,
+	}.MessageToBytes()
+
+	received, err := datagram.BytesToMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received.Signature) != 64 {
+		t.Fatalf("signature of %d bytes, want 64", len(received.Signature))
+	}
+	if BytesToHelloBody(received.Body).Name != "alice" {
+		t.Fatal("name changed")
+	}
+
+	// the signature covers the datagram as it arrived
+	signed := received.Raw[:len(received.Raw)-len(received.Signature)]
+	if err = crypto.VerifySignature(signed, received.Signature, crypto.FormatPublicKey(*publicKey)); err != nil {
+		t.Fatal(err)
+	}
+	received.Raw[7] ^= 1
+	if err = crypto.VerifySignature(signed, received.Signature, crypto.FormatPublicKey(*publicKey)); err == nil {
+		t.Fatal("signature verified over a modified datagram")
+	}
+}
+
+func TestBytesToMessageTruncated(t *testing.T) {
+	cases := []struct {
+		name     string
+		datagram UDPMessageBytes
+		want     error
+	}{
+		{"empty", UDPMessageBytes{}, ErrShortDatagram},
+		{"short header", UDPMessageBytes{0, 0, 0, 1, Hello, 0}, ErrShortDatagram},
+		{"short body", UDPMessageBytes{0, 0, 0, 1, Hello, 0, 10, 0, 0, 0, 0}, ErrTruncatedBody},
+		{"length beyond the datagram", UDPMessageBytes{0, 0, 0, 1, Datum, 0xff, 0xff, 1}, ErrTruncatedBody},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.datagram.BytesToMessage(); !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestCheckBody(t *testing.T) {
+	cases := []struct {
+		msgType uint8
+		length  int
+		valid   bool
+	}{
+		{Hello, 4, true},
+		{Hello, 3, false},
+		{HelloReply, 0, false},
+		{PublicKey, 0, true},
+		{PublicKey, 64, true},
+		{PublicKeyReply, 63, false},
+		{Root, 32, true},
+		{RootReply, 31, false},
+		{GetDatum, 33, false},
+		{Datum, 32, true},
+		{Datum, 31, false},
+		{NatTraversalRequest, 6, true},
+		{NatTraversal, 18, true},
+		{NatTraversal, 7, false},
+		{NoDatum, 0, true},
+		{ErrorReply, 0, true},
+	}
+	for _, c := range cases {
+		message := UDPMessage{Type: c.msgType, Length: uint16(c.length), Body: make([]byte, c.length)}
+		err := message.CheckBody()
+		if c.valid && err != nil {
+			t.Errorf("type %d with %d bytes refused: %v", c.msgType, c.length, err)
+		}
+		if !c.valid && !errors.Is(err, ErrMalformedBody) {
+			t.Errorf("type %d with %d bytes: got %v, want ErrMalformedBody", c.msgType, c.length, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package udptypes
+
+import (
+	"log/slog"
+	"net"
+	"protocoles-internet-2023/logging"
+	"strconv"
+)
+
+// messages sent and received on the socket
+var logger = logging.Logger("udp")
+
+// handling of the requests and transfers
+var schedLogger = logging.Logger("scheduler")
+
+var typeNames = map[uint8]string{
+	NoOp:                "NoOp",
+	Error:               "Error",
+	Hello:               "Hello",
+	PublicKey:           "PublicKey",
+	Root:                "Root",
+	GetDatum:            "GetDatum",
+	NatTraversalRequest: "NatTraversalRequest",
+	NatTraversal:        "NatTraversal",
+	ErrorReply:          "ErrorReply",
+	HelloReply:          "HelloReply",
+	PublicKeyReply:      "PublicKeyReply",
+	RootReply:           "RootReply",
+	Datum:               "Datum",
+	NoDatum:             "NoDatum",
+}
+
+/*
+Name of a message type, its number if it is unknown
+*/
+func TypeName(msgType uint8) string {
+	if name, ok := typeNames[msgType]; ok {
+		return name
+	}
+	return strconv.Itoa(int(msgType))
+}
+
+// id and type of a message as log attributes
+func messageAttrs(msg UDPMessage) slog.Attr {
+	return slog.Group("msg", slog.Uint64("id", uint64(msg.Id)), slog.String("type", TypeName(msg.Type)))
+}
+
+// name and address of a peer as log attributes, the name is only known after Hello
+func (sched *Scheduler) peerAttrs(addr net.Addr) slog.Attr {
+	if peer, ok := sched.PeerByAddress(addr); ok {
+		return slog.Group("peer", slog.String("name", peer.Name), slog.String("addr", addr.String()))
+	}
+	return slog.Group("peer", slog.String("addr", addr.String()))
+}
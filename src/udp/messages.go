@@ -1,72 +1,101 @@
 package udptypes
 
 import (
-	"fmt"
-	"math/rand"
+	crand "crypto/rand"
+	"encoding/binary"
 	"net"
 	"protocoles-internet-2023/config"
 	"protocoles-internet-2023/crypto"
 )
 
+/*
+Id of a new request, unpredictable: a signed reply carrying it was made for
+this request and not captured before, see challenge
+*/
+func newId() uint32 {
+	var id [4]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		panic("reading random bytes: " + err.Error())
+	}
+	return binary.BigEndian.Uint32(id[:])
+}
+
 func (sched *Scheduler) SendNoOp(dest *net.UDPAddr) {
 
-	if config.Debug {
-		fmt.Print("Sending NoOp to ")
-		peer, ok := sched.PeerDatabase[dest.String()]
-		if ok {
-			fmt.Println(peer.Name)
-		} else {
-			fmt.Println(dest.String())
-		}
+	msg := UDPMessage{
+		Id:         newId(),
+		Type:       NoOp,
+		Length:     0,
+		PrivateKey: sched.privateKey(),
 	}
+	sched.send(msg, dest)
+}
 
-	msg := UDPMessage{
-		Id:     uint32(rand.Int31()),
-		Type:   NoOp,
-		Length: 0,
+/*
+Sends a message expecting no reply, errors are only logged since nobody
+waits for the message
+*/
+func (sched *Scheduler) send(msg UDPMessage, dest *net.UDPAddr) {
+	err := sched.checkExtension(msg.Type, dest)
+	if err == nil {
+		err = sched.seal(&msg, dest)
+	}
+	if err == nil {
+		err = sched.Socket.SendPacket(msg, dest)
 	}
-	err := sched.Socket.SendPacket(msg, dest)
 	if err != nil {
+		logger.Warn("sending failed", sched.peerAttrs(dest), messageAttrs(msg), "err", err)
 		return
 	}
+
+	logger.Debug("sent", sched.peerAttrs(dest), messageAttrs(msg))
 }
 
-func (sched *Scheduler) SendHello(dest *net.UDPAddr) {
+/*
+Sends a request and waits for its reply, which is handled by the scheduler
+*/
+func (sched *Scheduler) sendRequest(msg UDPMessage, dest *net.UDPAddr) {
+	logger.Debug("sending", sched.peerAttrs(dest), messageAttrs(msg))
+
+	_, err := sched.SendPacket(msg, dest)
+	if err != nil {
+		logger.Warn("request failed", sched.peerAttrs(dest), messageAttrs(msg), "err", err)
+	}
+}
 
-	if config.Debug {
-		fmt.Print("Sending Hello to ")
-		peer, ok := sched.PeerDatabase[dest.String()]
-		if ok {
-			fmt.Println(peer.Name)
-		} else {
-			fmt.Println(dest.String())
-		}
+// name we give the peers
+func (sched *Scheduler) name() string {
+	if sched.Name != "" {
+		return sched.Name
 	}
+	return config.ClientName
+}
 
+func (sched *Scheduler) SendHello(dest *net.UDPAddr) {
+	sched.sendRequest(sched.helloMessage(), dest)
+}
+
+// a Hello with a new Id
+func (sched *Scheduler) helloMessage() UDPMessage {
 	body := HelloBody{
-		Name:       config.ClientName,
-		Extensions: 0,
+		Name:       sched.name(),
+		Extensions: sched.Extensions,
 	}.HelloBodyToBytes()
 
-	msg := UDPMessage{
-		Id:         uint32(rand.Int31()),
+	return UDPMessage{
+		Id:         newId(),
 		Type:       Hello,
 		Length:     uint16(len(body)),
 		Body:       body,
-		PrivateKey: sched.PrivateKey,
-	}
-	_, err := sched.SendPacket(msg, dest)
-	if err != nil {
-		fmt.Println("SendHello: ", err.Error())
-		return
+		PrivateKey: sched.privateKey(),
 	}
 }
 
 func (sched *Scheduler) SendHelloReply(dest *net.UDPAddr, id uint32) {
 
 	body := HelloBody{
-		Name:       config.ClientName,
-		Extensions: 0,
+		Name:       sched.name(),
+		Extensions: sched.Extensions,
 	}.HelloBodyToBytes()
 
 	msg := UDPMessage{
@@ -74,94 +103,74 @@ func (sched *Scheduler) SendHelloReply(dest *net.UDPAddr, id uint32) {
 		Type:       HelloReply,
 		Length:     uint16(len(body)),
 		Body:       body,
-		PrivateKey: sched.PrivateKey,
-	}
-	err := sched.Socket.SendPacket(msg, dest)
-	if err != nil {
-		return
-	}
-
-	if config.Debug {
-		fmt.Println("HelloReply sent to: " + sched.PeerDatabase[dest.String()].Name)
+		PrivateKey: sched.privateKey(),
 	}
+	sched.send(msg, dest)
 }
 
 func (sched *Scheduler) SendPublicKey(dest *net.UDPAddr) {
 
-	if config.Debug {
-		fmt.Println("Sending PublicKey to ", sched.PeerDatabase[dest.String()].Name)
-	}
-
+	keys := sched.keys.Load()
 	msg := UDPMessage{
-		Id:         uint32(rand.Int31()),
+		Id:         newId(),
 		Type:       PublicKey,
 		Length:     64,
-		Body:       crypto.FormatPublicKey(*sched.PublicKey),
-		PrivateKey: sched.PrivateKey,
-	}
-	_, err := sched.SendPacket(msg, dest)
-	if err != nil {
-		fmt.Println("SendPublicKey: ", err.Error())
-		return
+		Body:       crypto.FormatPublicKey(*keys.public),
+		PrivateKey: keys.private,
 	}
+	sched.sendRequest(msg, dest)
 }
 
 func (sched *Scheduler) SendPublicKeyReply(dest *net.UDPAddr, id uint32) {
 
+	keys := sched.keys.Load()
 	msg := UDPMessage{
 		Id:         id,
 		Type:       PublicKeyReply,
 		Length:     64,
-		Body:       crypto.FormatPublicKey(*sched.PublicKey),
-		PrivateKey: sched.PrivateKey,
-	}
-	err := sched.Socket.SendPacket(msg, dest)
-	if err != nil {
-		fmt.Println("SendPublicKeyReply: ", err.Error())
-		return
-	}
-
-	if config.Debug {
-		fmt.Println("PublicKeyReply sent to: " + sched.PeerDatabase[dest.String()].Name)
+		Body:       crypto.FormatPublicKey(*keys.public),
+		PrivateKey: keys.private,
 	}
+	sched.send(msg, dest)
 }
 
 func (sched *Scheduler) SendRoot(dest *net.UDPAddr) {
 
-	if config.Debug {
-		fmt.Println("Sending Root to ", sched.PeerDatabase[dest.String()].Name)
-	}
-
+	root := sched.RootFor(dest)
 	msg := UDPMessage{
-		Id:         uint32(rand.Int31()),
+		Id:         newId(),
 		Type:       Root,
 		Length:     32,
-		Body:       sched.ExportedFiles.Hash[:],
-		PrivateKey: sched.PrivateKey,
-	}
-	_, err := sched.SendPacket(msg, dest)
-	if err != nil {
-		fmt.Println("SendRoot: ", err.Error())
-		return
+		Body:       root[:],
+		PrivateKey: sched.privateKey(),
 	}
+	sched.sendRequest(msg, dest)
 }
 
 func (sched *Scheduler) SendRootReply(dest *net.UDPAddr, id uint32) {
 
+	root := sched.RootFor(dest)
 	msg := UDPMessage{
 		Id:         id,
 		Type:       RootReply,
 		Length:     32,
-		Body:       sched.ExportedFiles.Hash[:],
-		PrivateKey: sched.PrivateKey,
-	}
-	err := sched.Socket.SendPacket(msg, dest)
-	if err != nil {
-		fmt.Println("SendRootReply: ", err.Error())
-		return
+		Body:       root[:],
+		PrivateKey: sched.privateKey(),
 	}
+	sched.send(msg, dest)
+}
 
-	if config.Debug {
-		fmt.Println("RootReply sent to: " + sched.PeerDatabase[dest.String()].Name)
+/*
+Tells the peer why its request was refused
+*/
+func (sched *Scheduler) SendErrorReply(dest *net.UDPAddr, id uint32, reason string) {
+
+	msg := UDPMessage{
+		Id:         id,
+		Type:       ErrorReply,
+		Length:     uint16(len(reason)),
+		Body:       []byte(reason),
+		PrivateKey: sched.privateKey(),
 	}
+	sched.send(msg, dest)
 }
@@ -0,0 +1,145 @@
+package udptypes
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrMalformedAddress = errors.New("address must be 6 bytes for IPv4 or 18 for IPv6")
+
+/*
+Address in the format of NatTraversalRequest and NatTraversal: the IP on 4
+or 16 bytes then the port
+*/
+func AddressToBytes(addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+
+	bytes := make([]byte, len(ip)+2)
+	copy(bytes, ip)
+	bytes[len(ip)] = byte(addr.Port >> 8)
+	bytes[len(ip)+1] = byte(addr.Port)
+
+	return bytes
+}
+
+func BytesToAddress(bytes []byte) (*net.UDPAddr, error) {
+	if len(bytes) != 6 && len(bytes) != 18 {
+		return nil, ErrMalformedAddress
+	}
+
+	ip := make(net.IP, len(bytes)-2)
+	copy(ip, bytes)
+	port := int(bytes[len(bytes)-2])<<8 | int(bytes[len(bytes)-1])
+
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+/*
+Server we are registered with, which relays our address to the peers
+behind a NAT
+*/
+func (sched *Scheduler) SetServer(addr *net.UDPAddr) {
+	sched.serverLock.Lock()
+	defer sched.serverLock.Unlock()
+	sched.server = addr
+}
+
+func (sched *Scheduler) Server() *net.UDPAddr {
+	sched.serverLock.RLock()
+	defer sched.serverLock.RUnlock()
+	return sched.server
+}
+
+/*
+Asks the server to send our address to a peer that does not answer, the
+peer then sends us a Hello which opens its NAT to our messages
+Returns false when there is no server to ask
+*/
+func (sched *Scheduler) requestTraversal(dest *net.UDPAddr) bool {
+	server := sched.Server()
+	if server == nil || server.String() == dest.String() {
+		return false
+	}
+
+	body := AddressToBytes(dest)
+	msg := UDPMessage{
+		Id:         newId(),
+		Type:       NatTraversalRequest,
+		Length:     uint16(len(body)),
+		Body:       body,
+		PrivateKey: sched.privateKey(),
+	}
+
+	schedLogger.Info("peer unreachable, asking the server to relay our address", sched.peerAttrs(dest), "server", server.String())
+	sched.send(msg, server)
+
+	return true
+}
+
+/*
+The server relays the address of a peer trying to reach us: our Hello
+opens our NAT to it, its next attempt then gets through
+Only the server we are registered with is obeyed, anyone else could make us
+send messages to any address
+*/
+func (sched *Scheduler) handleTraversal(received UDPMessage, from net.Addr) {
+	server := sched.Server()
+	if server == nil || server.String() != from.String() {
+		schedLogger.Warn("NatTraversal not sent by the server, ignored", sched.peerAttrs(from), messageAttrs(received))
+		return
+	}
+
+	addr, err := BytesToAddress(received.Body)
+	if err != nil {
+		schedLogger.Warn("message dropped", sched.peerAttrs(from), messageAttrs(received), "err", err)
+		return
+	}
+
+	schedLogger.Info("hole punching", "to", addr.String())
+
+	// the receiving loop must not wait for the HelloReply, and the peer
+	// already asked the server so we do not ask it in turn
+	go func() {
+		if _, err := sched.hello(addr, false); err != nil {
+			schedLogger.Debug("hole punching Hello not answered", "to", addr.String(), "err", err)
+		}
+	}()
+}
+
+/*
+Acting as the server: sends the address of the peer asking for a traversal
+to the peer it could not reach
+Only registered peers are relayed to, the server must not send messages to
+any address it is given
+*/
+func (sched *Scheduler) relayTraversal(received UDPMessage, from *net.UDPAddr) {
+	if !sched.Relay {
+		schedLogger.Debug("NatTraversalRequest ignored, we are not a server", sched.peerAttrs(from), messageAttrs(received))
+		return
+	}
+
+	dest, err := BytesToAddress(received.Body)
+	if err != nil {
+		schedLogger.Warn("message dropped", sched.peerAttrs(from), messageAttrs(received), "err", err)
+		return
+	}
+	if _, ok := sched.PeerByAddress(dest); !ok {
+		schedLogger.Info("NatTraversalRequest for an unknown address, ignored", sched.peerAttrs(from), "to", dest.String())
+		return
+	}
+
+	body := AddressToBytes(from)
+	msg := UDPMessage{
+		Id:         newId(),
+		Type:       NatTraversal,
+		Length:     uint16(len(body)),
+		Body:       body,
+		PrivateKey: sched.privateKey(),
+	}
+
+	schedLogger.Info("relaying address for hole punching", sched.peerAttrs(from), "to", dest.String())
+	sched.send(msg, dest)
+}
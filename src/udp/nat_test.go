@@ -0,0 +1,55 @@
+package udptypes
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAddressBytes(t *testing.T) {
+	cases := []struct {
+		address string
+		length  int
+	}{
+		{"192.0.2.1:8443", 6},
+		{"127.0.0.1:1", 6},
+		{"[2001:db8::1]:65535", 18},
+	}
+	for _, c := range cases {
+		addr, err := net.ResolveUDPAddr("udp", c.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded := AddressToBytes(addr)
+		if len(encoded) != c.length {
+			t.Fatalf("%s encoded on %d bytes, want %d", c.address, len(encoded), c.length)
+		}
+
+		decoded, err := BytesToAddress(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.IP.Equal(addr.IP) || decoded.Port != addr.Port {
+			t.Fatalf("decoded %s, want %s", decoded, addr)
+		}
+	}
+}
+
+func TestBytesToAddressPort(t *testing.T) {
+	addr, err := BytesToAddress([]byte{10, 0, 0, 1, 0x20, 0xfb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "10.0.0.1:8443" {
+		t.Fatalf("got %s, want 10.0.0.1:8443", addr)
+	}
+}
+
+func TestBytesToAddressMalformed(t *testing.T) {
+	for _, length := range []int{0, 4, 5, 7, 16, 17, 19} {
+		if _, err := BytesToAddress(make([]byte, length)); !errors.Is(err, ErrMalformedAddress) {
+			t.Errorf("%d bytes: got %v, want ErrMalformedAddress", length, err)
+		}
+	}
+}
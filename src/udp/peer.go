@@ -1,14 +1,264 @@
 package udptypes
 
-import "errors"
+import (
+	"bytes"
+	"net"
+	"protocoles-internet-2023/crypto"
+	"time"
+)
 
-func (sched *Scheduler) GetPeerIPFromName(name string) (string, error) {
+// round-trip times kept for each peer
+const RTTHistory = 16
 
-	for key, value := range sched.PeerDatabase {
-		if value.Name == name {
-			return key, nil
+/*
+Identity of a peer, its name and the fingerprint of its key
+A peer keeps it when its address changes. A peer without key has none: a
+name alone proves nothing, such a peer is only known by its address
+*/
+func (peer *PeerInfo) ID() string {
+	key := peer.PublicKey()
+	if len(key) == 0 {
+		return ""
+	}
+	return peer.Name + " " + crypto.Fingerprint(key)
+}
+
+/*
+Key sent by the peer with PublicKey, empty if it does not sign
+*/
+func (peer *PeerInfo) PublicKey() []byte {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.publicKey
+}
+
+/*
+Whether the peer showed it holds the private key of its PublicKey: it signed
+a reply to a request we sent it, see challenge
+*/
+func (peer *PeerInfo) KeyProven() bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.keyProven
+}
+
+// unless the peer sent another key in the meantime, true when it was not proven yet
+func (peer *PeerInfo) proveKey(key []byte) bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if peer.keyProven || !bytes.Equal(peer.publicKey, key) {
+		return false
+	}
+	peer.keyProven = true
+	return true
+}
+
+/*
+Last root sent by the peer
+*/
+func (peer *PeerInfo) Root() [32]byte {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.root
+}
+
+/*
+Address where the peer was last authenticated
+*/
+func (peer *PeerInfo) Address() *net.UDPAddr {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.address
+}
+
+/*
+Last message accepted from the peer
+*/
+func (peer *PeerInfo) LastSeen() time.Time {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.lastSeen
+}
+
+// the peer sent its key, the bodies are encrypted from now on if negotiated
+func (sched *Scheduler) setPublicKey(peer *PeerInfo, key []byte) {
+	if len(key) == 0 {
+		key = nil
+	}
+	transport := sched.newTransport(peer, key)
+
+	peer.lock.Lock()
+	if !bytes.Equal(peer.publicKey, key) {
+		peer.keyProven = false
+	}
+	peer.publicKey = key
+	peer.transport = transport
+	peer.lock.Unlock()
+}
+
+func (peer *PeerInfo) setRoot(root [32]byte) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.root = root
+}
+
+// the requests and replies are now sent there
+func (peer *PeerInfo) setAddress(addr *net.UDPAddr) {
+	peer.lock.Lock()
+	changed := peer.address == nil || peer.address.String() != addr.String()
+	peer.address = addr
+	peer.lock.Unlock()
+
+	if changed {
+		peer.notify(PeerMoved)
+	}
+}
+
+// a message from the peer was accepted
+func (peer *PeerInfo) touch() {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.lastSeen = time.Now()
+}
+
+// the peer asked for a datum or sent one
+func (peer *PeerInfo) transferred() {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.transfer = time.Now()
+}
+
+/*
+Requests left without reply since the last answered one
+*/
+func (peer *PeerInfo) Failures() int {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.failures
+}
+
+// the peer answered a request, the previous failures are forgotten
+func (peer *PeerInfo) recordRTT(rtt time.Duration) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	peer.rtt = append(peer.rtt, rtt.Milliseconds())
+	if len(peer.rtt) > RTTHistory {
+		peer.rtt = peer.rtt[len(peer.rtt)-RTTHistory:]
+	}
+	peer.failures = 0
+}
+
+// a request was abandoned without reply
+func (peer *PeerInfo) recordFailure() {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.failures++
+}
+
+/*
+Peer known at this address
+*/
+func (sched *Scheduler) PeerByAddress(addr net.Addr) (*PeerInfo, bool) {
+	return sched.Peers.ByAddress(addr)
+}
+
+/*
+Last peer identified with this name, or the first one seen with it when none
+was identified yet
+*/
+func (sched *Scheduler) PeerByName(name string) (*PeerInfo, bool) {
+	return sched.Peers.ByName(name)
+}
+
+/*
+Address where the peer with this name was last authenticated
+*/
+func (sched *Scheduler) PeerAddress(name string) (*net.UDPAddr, bool) {
+	peer, ok := sched.Peers.ByName(name)
+	if !ok {
+		return nil, false
+	}
+	addr := peer.Address()
+	return addr, addr != nil
+}
+
+/*
+A Hello from an unknown address, signed by a peer that proved its key: the
+peer may have moved, e.g. its NAT mapping changed. It keeps its session once
+it answered a challenge at the new address, otherwise the Hello opens a new
+session. True when the Hello waits for the answer
+*/
+func (sched *Scheduler) migrate(name string, received UDPMessage, addr *net.UDPAddr) bool {
+	peer, ok := sched.Peers.ByName(name)
+	if !ok || received.Type != Hello || len(received.Signature) == 0 || !peer.KeyProven() {
+		return false
+	}
+	if state := peer.SessionState(); state == StateUnknown || state == StateExpired {
+		return false
+	}
+	key := peer.PublicKey()
+	if verifySignature(received, key) != nil {
+		return false
+	}
+
+	waiting := "move " + addr.String()
+	message := parkedMessage{received: received, from: addr}
+	if sched.proofs.park(waiting, message) {
+		return true
+	}
+	if !sched.proofs.begin(waiting, maxChallenges) {
+		return false
+	}
+	sched.proofs.park(waiting, message)
+
+	go func() {
+		// the Hello may have been sent again by anyone, the peer answers
+		// the challenge only if it is there
+		moved := sched.challenge(addr, key) && bytes.Equal(peer.PublicKey(), key)
+		if moved {
+			previous := peer.Address()
+			sched.Peers.bind(addr, peer)
+			peer.setAddress(addr)
+			schedLogger.Info("peer changed address", sched.peerAttrs(addr), "previous", previous.String())
+		}
+		for _, message := range sched.proofs.end(waiting) {
+			message.unmoved = !moved
+			sched.receiveParked(message)
 		}
+	}()
+	return true
+}
+
+/*
+A signed message came from another address of the peer: once the peer
+answered a challenge there, its replies and requests are sent there
+*/
+func (sched *Scheduler) follow(peer *PeerInfo, received UDPMessage, addr *net.UDPAddr) {
+	if len(received.Signature) == 0 || !peer.KeyProven() {
+		return
+	}
+	previous := peer.Address()
+	if previous != nil && previous.String() == addr.String() {
+		return
+	}
+	key := peer.PublicKey()
+	if verifySignature(received, key) != nil {
+		return
 	}
 
-	return "", errors.New("no peer with that name")
+	waiting := "follow " + addr.String()
+	if !sched.proofs.begin(waiting, maxChallenges) {
+		return
+	}
+	go func() {
+		defer sched.proofs.end(waiting)
+		if !sched.challenge(addr, key) || !bytes.Equal(peer.PublicKey(), key) {
+			return
+		}
+		if current, ok := sched.PeerByAddress(addr); ok && current == peer {
+			schedLogger.Debug("peer now answers at another address", sched.peerAttrs(addr), "previous", previous.String())
+			peer.setAddress(addr)
+		}
+	}()
 }
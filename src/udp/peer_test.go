@@ -0,0 +1,75 @@
+package udptypes
+
+import (
+	"protocoles-internet-2023/config"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	alice := newAccessScheduler(t, config.Access{})
+	alice.Name = "alice"
+	aliceAddr := listen(t, alice)
+	bob := newAccessScheduler(t, config.Access{})
+	bobAddr := listen(t, bob)
+	handshake(t, alice, bobAddr)
+
+	peer, ok := bob.PeerByAddress(aliceAddr)
+	if !ok {
+		t.Fatal("alice has no session with bob")
+	}
+
+	// alice behind another NAT mapping, with the same key
+	moved := NewScheduler(UDPSock{}, alice.Exports(), alice.privateKey(), alice.PublicKey())
+	moved.Name = "alice"
+	movedAddr := listen(t, moved)
+	if _, err := moved.Hello(bobAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	if current, _ := bob.PeerByAddress(movedAddr); current != peer {
+		t.Fatal("alice did not keep its session at its new address")
+	}
+	if peer.Address().String() != movedAddr.String() {
+		t.Fatalf("bob sends to %s, alice moved to %s", peer.Address(), movedAddr)
+	}
+}
+
+func TestReplayMovesNothing(t *testing.T) {
+	alice := newAccessScheduler(t, config.Access{})
+	alice.Name = "alice"
+	aliceAddr := listen(t, alice)
+	bob := newAccessScheduler(t, config.Access{})
+	bobAddr := listen(t, bob)
+	handshake(t, alice, bobAddr)
+
+	peer, ok := bob.PeerByAddress(aliceAddr)
+	if !ok {
+		t.Fatal("alice has no session with bob")
+	}
+
+	// a Hello alice signed, captured and sent again by eve
+	hello := HelloBody{Name: "alice"}.HelloBodyToBytes()
+	captured := UDPMessage{Id: newId(), Type: Hello, Body: hello, PrivateKey: alice.privateKey()}
+
+	eve := newImpostor(t, bobAddr)
+	eve.answer = func(challenge UDPMessage) {
+		eve.send(UDPMessage{Id: challenge.Id, Type: HelloReply, Body: hello})
+	}
+	eve.request(captured, HelloReply)
+	eve.send(captured)
+	eve.request(UDPMessage{Id: newId(), Type: PublicKey, Body: peer.PublicKey()}, PublicKeyReply)
+	eve.request(UDPMessage{Id: newId(), Type: Root, Body: emptyRoot[:]}, RootReply)
+
+	if current, _ := bob.PeerByAddress(aliceAddr); current != peer {
+		t.Fatal("address of alice moved by a replayed message")
+	}
+	if peer.Address().String() != aliceAddr.String() {
+		t.Fatalf("bob sends to %s instead of alice", peer.Address())
+	}
+	if named, _ := bob.PeerByName("alice"); named != peer {
+		t.Fatal("name of alice moved by a replayed message")
+	}
+	if impostor, _ := bob.PeerByAddress(eve.addr()); impostor == peer || impostor.KeyProven() {
+		t.Fatal("eve took the session of alice")
+	}
+}
@@ -0,0 +1,132 @@
+package udptypes
+
+import (
+	"errors"
+	"net"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/crypto"
+)
+
+// challenges running at once, the keys of the other peers stay unproven
+const maxChallenges = 32
+
+/*
+Sends a Hello with a new Id to addr and waits for its HelloReply, outside of
+the reception loop. True when the reply is signed with key: only the holder
+of the private key signs a reply to an Id nobody knew before, a message
+signed earlier and sent again does not answer it
+*/
+func (sched *Scheduler) challenge(addr *net.UDPAddr, key []byte) bool {
+	hello := sched.helloMessage()
+	replies, done := sched.replies.awaitChallenge(hello.Id, addr)
+	defer done()
+
+	timeout := config.RequestTimeout
+	for i := 0; i < config.RequestRetries; i++ {
+		if err := sched.Socket.SendPacket(hello, addr); err != nil {
+			schedLogger.Debug("sending failed", sched.peerAttrs(addr), messageAttrs(hello), "err", err)
+		}
+		if entry, ok := awaitReply(replies, timeout); ok {
+			reply := entry.Packet
+			return reply.Type == HelloReply && len(reply.Signature) != 0 && verifySignature(reply, key) == nil
+		}
+		timeout *= 2
+	}
+	return false
+}
+
+/*
+Asks the peer at addr to prove it holds the key it sent. Meanwhile its
+requests for our files wait, what it may read depends on the answer
+*/
+func (sched *Scheduler) challengeKey(peer *PeerInfo, addr *net.UDPAddr) {
+	key := peer.PublicKey()
+	if len(key) == 0 || peer.KeyProven() {
+		return
+	}
+	waiting := proofKey(addr)
+	if !sched.proofs.begin(waiting, maxChallenges) {
+		return
+	}
+
+	go func() {
+		if sched.challenge(addr, key) {
+			sched.keyProven(peer, key)
+		} else {
+			schedLogger.Info("peer did not prove its key", sched.peerAttrs(addr))
+		}
+		for _, message := range sched.proofs.end(waiting) {
+			sched.receive(message.received, message.from)
+		}
+	}()
+}
+
+// the requests for our files from addr wait for the proof of the key
+func proofKey(addr net.Addr) string {
+	return "key " + addr.String()
+}
+
+// requests that wait while the peer proves its key, see challengeKey
+func (sched *Scheduler) parkUntilKeyProven(received UDPMessage, from net.Addr) bool {
+	if received.Type != Root && received.Type != GetDatum {
+		return false
+	}
+	return sched.proofs.park(proofKey(from), parkedMessage{received: received, from: from})
+}
+
+/*
+A signed reply to a request we sent proves the key of the peer: the Id of
+the request is unpredictable and the reply came from where it was sent
+*/
+func (sched *Scheduler) proveReply(dest *net.UDPAddr, response SchedulerEntry) {
+	peer, ok := sched.PeerByAddress(dest)
+	if !ok || peer.KeyProven() || len(response.Packet.Signature) == 0 {
+		return
+	}
+	key := peer.PublicKey()
+	if len(key) != 0 && verifySignature(response.Packet, key) == nil {
+		sched.keyProven(peer, key)
+	}
+}
+
+// unless the peer sent another key meanwhile, the peer is then known by its key
+func (sched *Scheduler) keyProven(peer *PeerInfo, key []byte) {
+	if !peer.proveKey(key) {
+		return
+	}
+	schedLogger.Debug("peer proved its key", "peer", peer.Name)
+	sched.Peers.identify(peer)
+	sched.recordKnownKey(peer.Name, key)
+}
+
+/*
+A key differing from the pinned one was refused: once the peer proved it
+holds it, it can be accepted with accept-key
+*/
+func (sched *Scheduler) challengeChangedKey(name string, key []byte, addr *net.UDPAddr) {
+	if len(key) == 0 {
+		return
+	}
+	waiting := "changed " + addr.String()
+	if !sched.proofs.begin(waiting, maxChallenges) {
+		return
+	}
+	go func() {
+		defer sched.proofs.end(waiting)
+		if sched.challenge(addr, key) {
+			sched.recordKnownKey(name, key)
+		}
+	}()
+}
+
+// pins the key a peer proved it holds, see crypto.KnownPeers
+func (sched *Scheduler) recordKnownKey(name string, key []byte) {
+	if sched.KnownPeers == nil {
+		return
+	}
+	err := sched.KnownPeers.Record(name, key)
+	if errors.Is(err, crypto.ErrKeyChanged) {
+		schedLogger.Error("PUBLIC KEY OF THE PEER CHANGED, it may be impersonated: accept the new key only if you trust it",
+			"peer", name, "err", err)
+	}
+}
@@ -0,0 +1,199 @@
+package udptypes
+
+import (
+	"net"
+	"path/filepath"
+	"protocoles-internet-2023/config"
+	"protocoles-internet-2023/crypto"
+	"slices"
+	"testing"
+	"time"
+)
+
+// the scheduler receives on loopback until the end of the test
+func listen(t *testing.T, sched *Scheduler) *net.UDPAddr {
+	t.Helper()
+	sock, err := NewUDPSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.Socket = *sock
+	sched.SignaturePolicy = crypto.PolicyVerifyIfPresent
+	sched.Launch(sock)
+	t.Cleanup(func() {
+		sock.Socket.Close()
+		<-sched.stopped
+	})
+	return sock.Socket.LocalAddr().(*net.UDPAddr)
+}
+
+func openKnownPeers(t *testing.T) *crypto.KnownPeers {
+	t.Helper()
+	known, err := crypto.OpenKnownPeers(filepath.Join(t.TempDir(), "known_peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return known
+}
+
+/*
+Peer speaking to bob from a bare socket, with whatever messages it has
+Its answers to the challenges of bob are given to answer
+*/
+type impostor struct {
+	t      *testing.T
+	sock   *UDPSock
+	bob    *net.UDPAddr
+	answer func(challenge UDPMessage)
+}
+
+func newImpostor(t *testing.T, bob *net.UDPAddr) *impostor {
+	t.Helper()
+	sock, err := NewUDPSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Socket.Close() })
+	return &impostor{t: t, sock: sock, bob: bob, answer: func(UDPMessage) {}}
+}
+
+func (eve *impostor) addr() *net.UDPAddr {
+	return eve.sock.Socket.LocalAddr().(*net.UDPAddr)
+}
+
+func (eve *impostor) send(msg UDPMessage) {
+	eve.t.Helper()
+	msg.Length = uint16(len(msg.Body))
+	if err := eve.sock.SendPacket(msg, eve.bob); err != nil {
+		eve.t.Fatal(err)
+	}
+}
+
+// sends the request and returns its reply
+func (eve *impostor) request(msg UDPMessage, reply uint8) UDPMessage {
+	eve.t.Helper()
+	eve.send(msg)
+
+	eve.sock.Socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		received, _, err := eve.sock.ReceivePacket()
+		if err != nil {
+			eve.t.Fatalf("waiting for the reply to %d: %v", msg.Type, err)
+		}
+		switch {
+		case received.Type == Hello:
+			eve.answer(received)
+		case received.Id == msg.Id && received.Type == reply:
+			return received
+		case received.Id == msg.Id:
+			eve.t.Fatalf("reply %d to %d: %s", received.Type, msg.Type, received.Body)
+		}
+	}
+}
+
+// Hello, PublicKey and Root from alice to bob, returns the root of bob
+func handshake(t *testing.T, alice *Scheduler, bob *net.UDPAddr) [32]byte {
+	t.Helper()
+	if _, err := alice.Hello(bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.GetPublicKey(bob); err != nil {
+		t.Fatal(err)
+	}
+	root, err := alice.GetRoot(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestChallengeProvesKey(t *testing.T) {
+	alice := newAccessScheduler(t, config.Access{})
+	aliceAddr := listen(t, alice)
+	aliceKey := crypto.FormatPublicKey(*alice.PublicKey())
+
+	bob := newAccessScheduler(t, config.Access{
+		Shares: map[string][]string{"private": {crypto.Fingerprint(aliceKey)}},
+	})
+	bob.KnownPeers = openKnownPeers(t)
+	bobAddr := listen(t, bob)
+
+	// bob sends the root once alice answered its challenge
+	root := handshake(t, alice, bobAddr)
+
+	peer, ok := bob.PeerByAddress(aliceAddr)
+	if !ok || !peer.KeyProven() {
+		t.Fatal("alice did not prove its key to bob")
+	}
+	if root != bob.RootFor(aliceAddr) {
+		t.Fatal("root sent before alice proved its key")
+	}
+	if known, ok := bob.KnownPeers.Get(peer.Name); !ok || known.Fingerprint != crypto.Fingerprint(aliceKey) {
+		t.Fatal("proven key not pinned")
+	}
+	view, err := bob.exportsFor(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := shareNames(view); !slices.Equal(names, []string{"private", "public"}) {
+		t.Fatalf("alice sees %v, want both shares", names)
+	}
+
+	// the signed replies of bob to the requests of alice prove its key
+	if peer, ok := alice.PeerByAddress(bobAddr); !ok || !peer.KeyProven() {
+		t.Fatal("bob did not prove its key to alice")
+	}
+}
+
+func TestReplayProvesNothing(t *testing.T) {
+	alicePrivate, aliceKey := newKey(t)
+	bob := newAccessScheduler(t, config.Access{
+		Shares: map[string][]string{"private": {crypto.Fingerprint(aliceKey)}},
+	})
+	bob.KnownPeers = openKnownPeers(t)
+	bobAddr := listen(t, bob)
+
+	// messages alice signed earlier, captured by eve
+	hello := HelloBody{Name: "alice"}.HelloBodyToBytes()
+	captured := []UDPMessage{
+		{Id: 7, Type: HelloReply, Length: uint16(len(hello)), Body: hello, PrivateKey: alicePrivate},
+		{Id: 8, Type: NoOp, PrivateKey: alicePrivate},
+	}
+
+	eve := newImpostor(t, bobAddr)
+	eve.answer = func(challenge UDPMessage) {
+		for _, msg := range captured {
+			eve.send(msg)
+		}
+		// eve cannot sign the reply to the challenge
+		eve.send(UDPMessage{Id: challenge.Id, Type: HelloReply, Body: hello})
+	}
+
+	eve.request(UDPMessage{Id: newId(), Type: Hello, Body: hello}, HelloReply)
+	for _, msg := range captured {
+		eve.send(msg)
+	}
+	eve.request(UDPMessage{Id: newId(), Type: PublicKey, Body: aliceKey}, PublicKeyReply)
+	root := eve.request(UDPMessage{Id: newId(), Type: Root, Body: emptyRoot[:]}, RootReply)
+
+	peer, ok := bob.PeerByAddress(eve.addr())
+	if !ok {
+		t.Fatal("eve has no session with bob")
+	}
+	if peer.KeyProven() {
+		t.Fatal("key of alice proven by messages replayed by eve")
+	}
+	if _, ok := bob.KnownPeers.Get("alice"); ok {
+		t.Fatal("key pinned although not proven")
+	}
+	view, err := bob.exportsFor(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := shareNames(view); !slices.Equal(names, []string{"public"}) {
+		t.Fatalf("eve sees %v, want only the public share", names)
+	}
+	if [32]byte(root.Body) != bob.RootFor(eve.addr()) {
+		t.Fatal("eve got the root of alice")
+	}
+}
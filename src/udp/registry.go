@@ -0,0 +1,367 @@
+package udptypes
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// a peer silent for that long is forgotten, its session expired long ago
+const PeerExpiry = 30 * time.Minute
+
+// interval between two searches for idle peers
+const expiryInterval = time.Minute
+
+type PeerEventKind uint8
+
+const (
+	PeerAdded        PeerEventKind = iota // first message of a new session
+	PeerMoved                             // the peer is now reached at another address
+	PeerStateChanged                      // see SessionState
+	PeerExpired                           // forgotten after PeerExpiry without message
+)
+
+var eventNames = map[PeerEventKind]string{
+	PeerAdded:        "added",
+	PeerMoved:        "moved",
+	PeerStateChanged: "state changed",
+	PeerExpired:      "expired",
+}
+
+func (kind PeerEventKind) String() string {
+	return eventNames[kind]
+}
+
+/*
+A change of a peer, with its state right after it
+*/
+type PeerEvent struct {
+	Kind PeerEventKind
+	Peer PeerSnapshot
+}
+
+/*
+Copy of what is known about a peer at one time, safe to keep and read from
+any goroutine
+*/
+type PeerSnapshot struct {
+	Name        string
+	Address     *net.UDPAddr // where the peer was last authenticated
+	Addresses   []string     // every address the peer was seen at
+	PublicKey   []byte
+	KeyProven   bool // the peer showed it holds the private key of PublicKey
+	Root        [32]byte
+	State       SessionState
+	LastSeen    time.Time // last message accepted from the peer
+	Transfer    time.Time // last GetDatum or Datum exchanged, zero before
+	Established time.Time // end of the last handshake, zero before
+	RTT         []int64   // last round-trip times in ms, oldest first
+	Failures    int       // requests left without reply since the last answered one
+	Extensions  int32
+	Negotiated  int32
+	Encrypted   bool
+}
+
+/*
+Last round-trip time in milliseconds, 0 before the first reply
+*/
+func (snapshot PeerSnapshot) LastRTT() int64 {
+	if len(snapshot.RTT) == 0 {
+		return 0
+	}
+	return snapshot.RTT[len(snapshot.RTT)-1]
+}
+
+/*
+Time since the handshake completed, 0 if it did not
+*/
+func (snapshot PeerSnapshot) HandshakeAge() time.Duration {
+	if snapshot.Established.IsZero() {
+		return 0
+	}
+	return time.Since(snapshot.Established)
+}
+
+/*
+Copy of the peer, see PeerSnapshot
+*/
+func (peer *PeerInfo) Snapshot() PeerSnapshot {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	return PeerSnapshot{
+		Name:        peer.Name,
+		Address:     peer.address,
+		Addresses:   slices.Clone(peer.addresses),
+		PublicKey:   peer.publicKey,
+		KeyProven:   peer.keyProven,
+		Root:        peer.root,
+		State:       peer.sessionState(),
+		LastSeen:    peer.lastSeen,
+		Transfer:    peer.transfer,
+		Established: peer.established,
+		RTT:         slices.Clone(peer.rtt),
+		Failures:    peer.failures,
+		Extensions:  peer.Extensions,
+		Negotiated:  peer.Negotiated,
+		Encrypted:   peer.transport != nil,
+	}
+}
+
+// tells the watchers of the registry, the lock of the peer must not be held
+func (peer *PeerInfo) notify(kind PeerEventKind) {
+	peer.lock.Lock()
+	registry := peer.registry
+	peer.lock.Unlock()
+
+	if registry != nil {
+		registry.notify(PeerEvent{Kind: kind, Peer: peer.Snapshot()})
+	}
+}
+
+/*
+Peers we exchanged messages with, found by address, identity or name
+The registry is safe for concurrent use: the lock of the registry is always
+taken before the one of a peer
+*/
+type PeerRegistry struct {
+	lock      sync.RWMutex
+	byAddress map[string]*PeerInfo // every address of a peer leads to it
+	byID      map[string]*PeerInfo // see PeerInfo.ID
+	byName    map[string]*PeerInfo
+
+	watchers     map[chan PeerEvent]bool
+	watchersLock sync.Mutex
+}
+
+/*
+PeerRegistry "constructor"
+*/
+func NewPeerRegistry() *PeerRegistry {
+	return &PeerRegistry{
+		byAddress: make(map[string]*PeerInfo),
+		byID:      make(map[string]*PeerInfo),
+		byName:    make(map[string]*PeerInfo),
+		watchers:  make(map[chan PeerEvent]bool),
+	}
+}
+
+/*
+Peer known at this address
+*/
+func (reg *PeerRegistry) ByAddress(addr net.Addr) (*PeerInfo, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	peer, ok := reg.byAddress[addr.String()]
+	return peer, ok
+}
+
+/*
+Last peer identified with this name, or the first one seen with it when none
+was identified yet
+*/
+func (reg *PeerRegistry) ByName(name string) (*PeerInfo, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	peer, ok := reg.byName[name]
+	return peer, ok
+}
+
+/*
+Every peer of the registry, by name
+*/
+func (reg *PeerRegistry) All() []*PeerInfo {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	return reg.all()
+}
+
+// the lock of the registry is held
+func (reg *PeerRegistry) all() []*PeerInfo {
+	seen := make(map[*PeerInfo]bool)
+	var peers []*PeerInfo
+	for _, index := range []map[string]*PeerInfo{reg.byName, reg.byID, reg.byAddress} {
+		for _, peer := range index {
+			if !seen[peer] {
+				seen[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+	return peers
+}
+
+/*
+Copy of every peer of the registry, by name
+*/
+func (reg *PeerRegistry) Snapshot() []PeerSnapshot {
+	peers := reg.All()
+	snapshots := make([]PeerSnapshot, 0, len(peers))
+	for _, peer := range peers {
+		snapshots = append(snapshots, peer.Snapshot())
+	}
+	return snapshots
+}
+
+// the peer is known at this address, which no longer leads to another peer
+func (reg *PeerRegistry) bind(addr *net.UDPAddr, peer *PeerInfo) {
+	key := addr.String()
+
+	reg.lock.Lock()
+	previous, ok := reg.byAddress[key]
+	if ok && previous != peer {
+		previous.lock.Lock()
+		previous.addresses = slices.DeleteFunc(previous.addresses, func(address string) bool {
+			return address == key
+		})
+		previous.lock.Unlock()
+	}
+
+	reg.byAddress[key] = peer
+	// until a peer with that name is identified by its key, a new session
+	// at the same address, or once the previous one ended, replaces it
+	if named, found := reg.byName[peer.Name]; !found || (ok && named == previous) || named.SessionState() == StateExpired {
+		reg.byName[peer.Name] = peer
+	}
+
+	peer.lock.Lock()
+	added := peer.registry == nil
+	peer.registry = reg
+	if !slices.Contains(peer.addresses, key) {
+		peer.addresses = append(peer.addresses, key)
+	}
+	if peer.address == nil {
+		peer.address = addr
+	}
+	peer.lock.Unlock()
+	reg.lock.Unlock()
+
+	if added {
+		peer.notify(PeerAdded)
+	}
+}
+
+/*
+Called once the key of the peer is known: the addresses of a previous
+session of the same peer now lead to this one
+Only a peer that proved its key is identified, see challenge, the others
+keep their address: anyone can send a name, the key of another peer, or
+messages signed by it earlier
+*/
+func (reg *PeerRegistry) identify(peer *PeerInfo) {
+	if !peer.KeyProven() {
+		return
+	}
+	id := peer.ID()
+
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if previous, ok := reg.byID[id]; ok && previous != peer {
+		previous.lock.Lock()
+		addresses := slices.Clone(previous.addresses)
+		previous.lock.Unlock()
+
+		for _, address := range addresses {
+			if reg.byAddress[address] != previous {
+				continue
+			}
+			reg.byAddress[address] = peer
+			peer.lock.Lock()
+			if !slices.Contains(peer.addresses, address) {
+				peer.addresses = append(peer.addresses, address)
+			}
+			peer.lock.Unlock()
+		}
+	}
+
+	reg.byID[id] = peer
+	reg.byName[peer.Name] = peer
+}
+
+/*
+Forgets the peers silent for longer than idle and returns them
+*/
+func (reg *PeerRegistry) Expire(idle time.Duration) []PeerSnapshot {
+	reg.lock.Lock()
+	expired := make(map[*PeerInfo]bool)
+	for _, peer := range reg.all() {
+		if time.Since(peer.LastSeen()) > idle {
+			expired[peer] = true
+		}
+	}
+	for _, index := range []map[string]*PeerInfo{reg.byName, reg.byID, reg.byAddress} {
+		for key, peer := range index {
+			if expired[peer] {
+				delete(index, key)
+			}
+		}
+	}
+	reg.lock.Unlock()
+
+	var snapshots []PeerSnapshot
+	for peer := range expired {
+		snapshot := peer.Snapshot()
+		snapshots = append(snapshots, snapshot)
+		reg.notify(PeerEvent{Kind: PeerExpired, Peer: snapshot})
+	}
+	return snapshots
+}
+
+/*
+Channel receiving the changes of the peers until cancel is called
+Events are dropped while the channel is full, so that a slow reader never
+holds the reception of packets: it can catch up with Snapshot
+*/
+func (reg *PeerRegistry) Watch(size int) (events <-chan PeerEvent, cancel func()) {
+	watcher := make(chan PeerEvent, size)
+
+	reg.watchersLock.Lock()
+	reg.watchers[watcher] = true
+	reg.watchersLock.Unlock()
+
+	cancel = func() {
+		reg.watchersLock.Lock()
+		defer reg.watchersLock.Unlock()
+		if reg.watchers[watcher] {
+			delete(reg.watchers, watcher)
+			close(watcher)
+		}
+	}
+	return watcher, cancel
+}
+
+func (reg *PeerRegistry) notify(event PeerEvent) {
+	reg.watchersLock.Lock()
+	defer reg.watchersLock.Unlock()
+
+	for watcher := range reg.watchers {
+		select {
+		case watcher <- event:
+		default:
+		}
+	}
+}
+
+// forgets the idle peers until the reception loop ends
+func (sched *Scheduler) expirePeers() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, peer := range sched.Peers.Expire(PeerExpiry) {
+				schedLogger.Debug("peer forgotten", "peer", peer.Name, "last_seen", peer.LastSeen.Format(time.DateTime))
+			}
+		case <-sched.stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package udptypes
+
+import (
+	"net"
+	"testing"
+)
+
+func testAddr(port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: port}
+}
+
+func TestIdentifyKeylessPeer(t *testing.T) {
+	reg := NewPeerRegistry()
+
+	bob := &PeerInfo{Name: "bob"}
+	reg.bind(testAddr(1), bob)
+	reg.identify(bob)
+
+	// a name alone proves nothing, the addresses of bob stay his
+	impostor := &PeerInfo{Name: "bob"}
+	reg.bind(testAddr(2), impostor)
+	reg.identify(impostor)
+
+	if peer, _ := reg.ByAddress(testAddr(1)); peer != bob {
+		t.Fatal("address of a keyless peer taken by another peer with its name")
+	}
+	if peer, _ := reg.ByName("bob"); peer != bob {
+		t.Fatal("name of a keyless peer taken by another peer")
+	}
+	if peer, _ := reg.ByAddress(testAddr(2)); peer != impostor {
+		t.Fatal("keyless peer not known by its address")
+	}
+}
+
+func TestIdentifyProvenKey(t *testing.T) {
+	reg := NewPeerRegistry()
+	_, key := newKey(t)
+
+	previous := &PeerInfo{Name: "bob", publicKey: key, keyProven: true}
+	reg.bind(testAddr(1), previous)
+	reg.identify(previous)
+
+	// the key sent but not proven does not move the addresses
+	claimed := &PeerInfo{Name: "bob", publicKey: key}
+	reg.bind(testAddr(2), claimed)
+	reg.identify(claimed)
+	if peer, _ := reg.ByAddress(testAddr(1)); peer != previous {
+		t.Fatal("addresses moved to a peer that did not prove its key")
+	}
+
+	// a new session of bob, from another address
+	current := &PeerInfo{Name: "bob", publicKey: key, keyProven: true}
+	reg.bind(testAddr(3), current)
+	reg.identify(current)
+	if peer, _ := reg.ByAddress(testAddr(1)); peer != current {
+		t.Fatal("addresses of the previous session not moved to the new one")
+	}
+	if peer, _ := reg.ByName("bob"); peer != current {
+		t.Fatal("name not moved to the new session")
+	}
+}
+
+func TestBindNewSessionSameAddress(t *testing.T) {
+	reg := NewPeerRegistry()
+
+	first := &PeerInfo{Name: "bob"}
+	reg.bind(testAddr(1), first)
+	second := &PeerInfo{Name: "bob"}
+	reg.bind(testAddr(1), second)
+
+	if peer, _ := reg.ByName("bob"); peer != second {
+		t.Fatal("new session at the same address not found by name")
+	}
+}
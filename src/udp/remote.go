@@ -0,0 +1,423 @@
+package udptypes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/filestructure"
+	"strings"
+)
+
+var ErrNoDatum = errors.New("peer has no datum for this hash")
+var ErrNotDirectory = errors.New("not a directory")
+var ErrNotFile = errors.New("not a file")
+var ErrNotFound = errors.New("no such file or directory")
+
+/*
+Parses the value of a datum into a node
+The hash is not checked here, see FetchNode
+*/
+func ParseDatum(body DatumBody) (RemoteNode, error) {
+	if len(body.Value) == 0 {
+		return RemoteNode{}, errors.New("empty datum")
+	}
+
+	node := RemoteNode{
+		Hash: body.Hash,
+		Type: body.Value[0],
+	}
+
+	switch node.Type {
+	case ChunkNode:
+		node.Data = body.Value[1:]
+	case BigfileNode:
+		if (len(body.Value)-1)%32 != 0 {
+			return RemoteNode{}, errors.New("malformed bigfile datum")
+		}
+		for i := 1; i < len(body.Value); i += 32 {
+			node.Children = append(node.Children, filestructure.Child{
+				Hash: [32]byte(body.Value[i : i+32]),
+			})
+		}
+	case DirectoryNode:
+		if (len(body.Value)-1)%64 != 0 {
+			return RemoteNode{}, errors.New("malformed directory datum")
+		}
+		for i := 1; i < len(body.Value); i += 64 {
+			node.Children = append(node.Children, filestructure.Child{
+				Name: string(bytes.TrimRight(body.Value[i:i+32], "\x00")),
+				Hash: [32]byte(body.Value[i+32 : i+64]),
+			})
+		}
+	default:
+		return RemoteNode{}, errors.New("unknown datum type")
+	}
+
+	return node, nil
+}
+
+/*
+Fetches a single node of the peer's tree
+The received datum must hash to the requested hash, which verifies the
+whole path from the root when nodes are fetched from the top down
+*/
+func (sched *Scheduler) FetchNode(hash [32]byte, dest *net.UDPAddr) (RemoteNode, error) {
+
+	sched.Cache.Lock.Lock()
+	node, ok := sched.Cache.Nodes[hash]
+	sched.Cache.Lock.Unlock()
+	if ok {
+		return node, nil
+	}
+
+	getDatum := UDPMessage{
+		Id:         newId(),
+		Type:       GetDatum,
+		Length:     32,
+		Body:       hash[:],
+		PrivateKey: sched.privateKey(),
+	}
+
+	packet, err := sched.SendPacket(getDatum, dest)
+	if err != nil {
+		return RemoteNode{}, errors.New("fetching node: " + err.Error())
+	}
+
+	if packet.Packet.Type == NoDatum {
+		return RemoteNode{}, ErrNoDatum
+	}
+	if err = checkReply(packet, Datum, "GetDatum"); err != nil {
+		return RemoteNode{}, err
+	}
+	if len(packet.Packet.Body) <= 32 {
+		return RemoteNode{}, errors.New("unexpected reply to GetDatum")
+	}
+
+	body := BytesToDatumBody(packet.Packet.Body)
+	if body.Hash != hash || !verifyDatumHash(body) {
+		return RemoteNode{}, errors.New("merkle tree could not verify hash")
+	}
+
+	node, err = ParseDatum(body)
+	if err != nil {
+		return RemoteNode{}, err
+	}
+
+	sched.Cache.Lock.Lock()
+	if node.Type == ChunkNode {
+		sched.Cache.Sizes[hash] = int64(len(node.Data))
+	} else {
+		sched.Cache.Nodes[hash] = node
+	}
+	sched.Cache.Lock.Unlock()
+
+	return node, nil
+}
+
+/*
+Sends Hello to the peer and waits for its HelloReply
+Without reply, the peer may be behind a NAT: the server is asked to relay
+our address to it, and the Hello is sent again
+*/
+func (sched *Scheduler) Hello(dest *net.UDPAddr) (HelloBody, error) {
+	return sched.hello(dest, true)
+}
+
+func (sched *Scheduler) hello(dest *net.UDPAddr, traverse bool) (HelloBody, error) {
+	hello := sched.helloMessage()
+	packet, err := sched.SendPacket(hello, dest)
+	if errors.Is(err, ErrNoResponse) && traverse && sched.requestTraversal(dest) {
+		packet, err = sched.SendPacket(hello, dest)
+	}
+	if err != nil {
+		return HelloBody{}, errors.New("hello: " + err.Error())
+	}
+	if err = checkReply(packet, HelloReply, "Hello"); err != nil {
+		return HelloBody{}, err
+	}
+	if len(packet.Packet.Body) < 4 {
+		return HelloBody{}, errors.New("unexpected reply to Hello")
+	}
+
+	return BytesToHelloBody(packet.Packet.Body), nil
+}
+
+/*
+Sends our public key to the peer and returns the one from its PublicKeyReply,
+which is empty if the peer does not sign its messages
+*/
+func (sched *Scheduler) GetPublicKey(dest *net.UDPAddr) ([]byte, error) {
+	keys := sched.keys.Load()
+	key := UDPMessage{
+		Id:         newId(),
+		Type:       PublicKey,
+		Length:     64,
+		Body:       crypto.FormatPublicKey(*keys.public),
+		PrivateKey: keys.private,
+	}
+	packet, err := sched.SendPacket(key, dest)
+	if err != nil {
+		return nil, errors.New("public key: " + err.Error())
+	}
+	if err = checkReply(packet, PublicKeyReply, "PublicKey"); err != nil {
+		return nil, err
+	}
+	if len(packet.Packet.Body) != 0 && len(packet.Packet.Body) != 64 {
+		return nil, errors.New("unexpected reply to PublicKey")
+	}
+
+	return packet.Packet.Body, nil
+}
+
+/*
+Sends our root to the peer and returns the one from its RootReply
+*/
+func (sched *Scheduler) GetRoot(dest *net.UDPAddr) ([32]byte, error) {
+	ours := sched.RootFor(dest)
+	root := UDPMessage{
+		Id:         newId(),
+		Type:       Root,
+		Length:     32,
+		Body:       ours[:],
+		PrivateKey: sched.privateKey(),
+	}
+	packet, err := sched.SendPacket(root, dest)
+	if err != nil {
+		return [32]byte{}, errors.New("root: " + err.Error())
+	}
+	if err = checkReply(packet, RootReply, "Root"); err != nil {
+		return [32]byte{}, err
+	}
+	if len(packet.Packet.Body) != 32 {
+		return [32]byte{}, errors.New("unexpected reply to Root")
+	}
+
+	return [32]byte(packet.Packet.Body), nil
+}
+
+/*
+Checks the type of the reply to a request, an ErrorReply carries the reason
+given by the peer
+*/
+func checkReply(entry SchedulerEntry, expected uint8, request string) error {
+	if entry.Err != nil {
+		return fmt.Errorf("%s refused: %w", request, entry.Err)
+	}
+	if entry.Packet.Type == ErrorReply {
+		return errors.New(request + " refused: " + string(entry.Packet.Body))
+	}
+	if entry.Packet.Type != expected {
+		return errors.New("unexpected reply to " + request)
+	}
+	return nil
+}
+
+/*
+Connects to the peer if needed and fetches its current root node
+The root is asked again since the peer may have changed its exports
+*/
+func (sched *Scheduler) FetchRoot(dest *net.UDPAddr) (RemoteNode, error) {
+
+	// a new session has just exchanged the roots
+	if sched.SessionState(dest) != StateEstablished {
+		peer, err := sched.Connect(dest)
+		if err != nil {
+			return RemoteNode{}, err
+		}
+		return sched.FetchNode(peer.Root(), dest)
+	}
+
+	root, err := sched.GetRoot(dest)
+	if err != nil {
+		return RemoteNode{}, err
+	}
+
+	return sched.FetchNode(root, dest)
+}
+
+/*
+Follows a slash separated path from the given directory node
+An empty path designates the node itself
+*/
+func (sched *Scheduler) ResolvePath(node RemoteNode, path string, dest *net.UDPAddr) (RemoteNode, error) {
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		if node.Type != DirectoryNode {
+			return RemoteNode{}, ErrNotDirectory
+		}
+
+		found := false
+		for _, child := range node.Children {
+			if child.Name == name {
+				next, err := sched.FetchNode(child.Hash, dest)
+				if err != nil {
+					return RemoteNode{}, err
+				}
+				next.Name = child.Name
+				node = next
+				found = true
+				break
+			}
+		}
+		if !found {
+			return RemoteNode{}, ErrNotFound
+		}
+	}
+
+	return node, nil
+}
+
+/*
+Returns the size in bytes of a remote file
+Unknown sizes require fetching the chunks, known ones come from the cache
+*/
+func (sched *Scheduler) NodeSize(node RemoteNode, dest *net.UDPAddr) (int64, error) {
+
+	switch node.Type {
+	case ChunkNode:
+		return int64(len(node.Data)), nil
+	case BigfileNode:
+		if size, ok := sched.knownSize(node.Hash); ok {
+			return size, nil
+		}
+
+		var size int64
+		for _, child := range node.Children {
+			childSize, ok := sched.knownSize(child.Hash)
+			if !ok {
+				childNode, err := sched.FetchNode(child.Hash, dest)
+				if err != nil {
+					return 0, err
+				}
+				childSize, err = sched.NodeSize(childNode, dest)
+				if err != nil {
+					return 0, err
+				}
+			}
+			size += childSize
+		}
+
+		sched.Cache.Lock.Lock()
+		sched.Cache.Sizes[node.Hash] = size
+		sched.Cache.Lock.Unlock()
+
+		return size, nil
+	default:
+		return 0, ErrNotFile
+	}
+}
+
+func (sched *Scheduler) knownSize(hash [32]byte) (int64, bool) {
+	sched.Cache.Lock.Lock()
+	defer sched.Cache.Lock.Unlock()
+
+	size, ok := sched.Cache.Sizes[hash]
+	return size, ok
+}
+
+type rangeCopy struct {
+	w         io.Writer
+	offset    int64
+	remaining int64 // negative means until the end of the file
+	written   int64
+}
+
+/*
+Writes the content of a remote file to w, chunk after chunk in order
+The first offset bytes are skipped and at most length bytes are written,
+a negative length copies until the end of the file
+*/
+func (sched *Scheduler) CopyFile(w io.Writer, node RemoteNode, dest *net.UDPAddr, offset int64, length int64) (int64, error) {
+	state := rangeCopy{
+		w:         w,
+		offset:    offset,
+		remaining: length,
+	}
+	err := sched.copyNode(&state, node, dest)
+	return state.written, err
+}
+
+func (sched *Scheduler) copyNode(state *rangeCopy, node RemoteNode, dest *net.UDPAddr) error {
+
+	switch node.Type {
+	case ChunkNode:
+		data := node.Data
+		if state.offset >= int64(len(data)) {
+			state.offset -= int64(len(data))
+			return nil
+		}
+		data = data[state.offset:]
+		state.offset = 0
+
+		if state.remaining >= 0 && int64(len(data)) > state.remaining {
+			data = data[:state.remaining]
+		}
+
+		n, err := state.w.Write(data)
+		state.written += int64(n)
+		if state.remaining >= 0 {
+			state.remaining -= int64(n)
+		}
+		return err
+	case BigfileNode:
+		for _, child := range node.Children {
+			if state.remaining == 0 {
+				return nil
+			}
+			if size, ok := sched.knownSize(child.Hash); ok && size <= state.offset {
+				state.offset -= size
+				continue
+			}
+
+			childNode, err := sched.FetchNode(child.Hash, dest)
+			if err != nil {
+				return err
+			}
+			if err = sched.copyNode(state, childNode, dest); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ErrNotFile
+	}
+}
+
+/*
+Walks the tree below node depth first, in the order of the directory entries
+Nodes are fetched one at a time just before fn is called on them, with their
+slash separated path built from the given prefix
+*/
+func (sched *Scheduler) WalkTree(node RemoteNode, prefix string, dest *net.UDPAddr, fn func(path string, node RemoteNode) error) error {
+
+	if err := fn(prefix, node); err != nil {
+		return err
+	}
+	if node.Type != DirectoryNode {
+		return nil
+	}
+
+	for _, child := range node.Children {
+		childNode, err := sched.FetchNode(child.Hash, dest)
+		if err != nil {
+			return err
+		}
+		childNode.Name = child.Name
+
+		childPath := child.Name
+		if prefix != "" {
+			childPath = prefix + "/" + child.Name
+		}
+		if err = sched.WalkTree(childNode, childPath, dest, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package udptypes
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+Requests waiting for their reply, by Id
+A reply goes to the request with its Id when it comes from an address the
+request was sent to, or is dropped: the request was abandoned, already
+answered, or the reply is spoofed.
+The reception loop never waits for a request
+*/
+type pendingReplies struct {
+	lock    sync.Mutex
+	waiting map[uint32]pendingRequest
+}
+
+type pendingRequest struct {
+	reply     chan SchedulerEntry
+	dests     []*net.UDPAddr // where the request was sent
+	challenge bool           // the reply is not handled by the scheduler, see challenge
+}
+
+/*
+Registers a request before it is sent to dests, done removes it
+The channel gets the first reply, the following ones are dropped
+*/
+func (pending *pendingReplies) await(id uint32, dests ...*net.UDPAddr) (replies <-chan SchedulerEntry, done func()) {
+	return pending.register(id, pendingRequest{dests: dests})
+}
+
+// the reply goes to the request as it arrived, see deliverChallenge
+func (pending *pendingReplies) awaitChallenge(id uint32, dest *net.UDPAddr) (replies <-chan SchedulerEntry, done func()) {
+	return pending.register(id, pendingRequest{dests: []*net.UDPAddr{dest}, challenge: true})
+}
+
+func (pending *pendingReplies) register(id uint32, request pendingRequest) (replies <-chan SchedulerEntry, done func()) {
+	reply := make(chan SchedulerEntry, 1)
+	request.reply = reply
+
+	pending.lock.Lock()
+	if pending.waiting == nil {
+		pending.waiting = make(map[uint32]pendingRequest)
+	}
+	pending.waiting[id] = request
+	pending.lock.Unlock()
+
+	return reply, func() {
+		pending.lock.Lock()
+		defer pending.lock.Unlock()
+		if pending.waiting[id].reply == reply {
+			delete(pending.waiting, id)
+		}
+	}
+}
+
+// false when no request sent to the sender of the reply waits for it
+func (pending *pendingReplies) deliver(entry SchedulerEntry) bool {
+	return pending.deliverTo(entry, false)
+}
+
+/*
+Hands a reply to the challenge with its Id, before the scheduler handles
+it. False when it answers no challenge
+*/
+func (pending *pendingReplies) deliverChallenge(entry SchedulerEntry) bool {
+	return pending.deliverTo(entry, true)
+}
+
+func (pending *pendingReplies) deliverTo(entry SchedulerEntry, challenge bool) bool {
+	pending.lock.Lock()
+	request, ok := pending.waiting[entry.Packet.Id]
+	pending.lock.Unlock()
+	if !ok || request.challenge != challenge || !request.sentTo(entry.From) {
+		return false
+	}
+
+	select {
+	case request.reply <- entry:
+		return true
+	default:
+		// already answered, e.g. the reply to a retransmission. The reply to a
+		// challenge is still not handled by the scheduler
+		return challenge
+	}
+}
+
+func (request pendingRequest) sentTo(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	for _, dest := range request.dests {
+		if dest.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Hands a reply to the request with its Id, without waiting
+*/
+func (sched *Scheduler) deliver(received UDPMessage, from net.Addr) {
+	sched.deliverEntry(SchedulerEntry{From: from, Time: time.Now(), Packet: received})
+}
+
+func (sched *Scheduler) deliverEntry(entry SchedulerEntry) {
+	if !sched.replies.deliver(entry) {
+		schedLogger.Debug("reply dropped, no request waiting for it", sched.peerAttrs(entry.From), messageAttrs(entry.Packet))
+	}
+}
@@ -0,0 +1,77 @@
+package udptypes
+
+import (
+	"testing"
+	"time"
+)
+
+func reply(id uint32) SchedulerEntry {
+	return SchedulerEntry{From: testAddr(1), Time: time.Now(), Packet: UDPMessage{Id: id, Type: Datum}}
+}
+
+func TestRepliesRoutedById(t *testing.T) {
+	var pending pendingReplies
+
+	replies, done := pending.await(1, testAddr(1))
+	defer done()
+
+	if pending.deliver(reply(2)) {
+		t.Fatal("reply delivered to a request with another Id")
+	}
+	if !pending.deliver(reply(1)) {
+		t.Fatal("reply not delivered")
+	}
+	// a duplicate, e.g. the reply to a retransmission, does not wait
+	if pending.deliver(reply(1)) {
+		t.Fatal("second reply delivered")
+	}
+
+	select {
+	case entry := <-replies:
+		if entry.Packet.Id != 1 {
+			t.Fatalf("got the reply to %d", entry.Packet.Id)
+		}
+	default:
+		t.Fatal("no reply received")
+	}
+}
+
+func TestRepliesWithoutRequest(t *testing.T) {
+	var pending pendingReplies
+
+	_, done := pending.await(1, testAddr(1))
+	done()
+
+	delivered := make(chan bool)
+	go func() {
+		delivered <- pending.deliver(reply(1))
+	}()
+
+	select {
+	case ok := <-delivered:
+		if ok {
+			t.Fatal("reply delivered to an abandoned request")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivering a reply nobody waits for blocks")
+	}
+}
+
+func TestRepliesFromDestination(t *testing.T) {
+	var pending pendingReplies
+
+	replies, done := pending.await(1, testAddr(1))
+	defer done()
+
+	spoofed := reply(1)
+	spoofed.From = testAddr(2)
+	if pending.deliver(spoofed) {
+		t.Fatal("reply from another address delivered")
+	}
+	if !pending.deliver(reply(1)) {
+		t.Fatal("reply from the destination not delivered")
+	}
+	if entry := <-replies; entry.From.String() != testAddr(1).String() {
+		t.Fatalf("got the reply from %s", entry.From)
+	}
+}
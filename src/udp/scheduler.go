@@ -2,17 +2,17 @@ package udptypes
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
-	"math/rand"
 	"net"
 	"protocoles-internet-2023/config"
 	"protocoles-internet-2023/crypto"
 	"protocoles-internet-2023/filestructure"
+	"protocoles-internet-2023/logging"
 	"strconv"
-	"sync"
 	"time"
 )
 
@@ -21,196 +21,239 @@ Scheduler "constructor"
 */
 func NewScheduler(sock UDPSock, files *filestructure.Directory, prKey *ecdsa.PrivateKey, pubKey *ecdsa.PublicKey) *Scheduler {
 	sched := Scheduler{
-		Socket:         sock,
-		PeerDatabase:   make(map[string]*PeerInfo),
-		PrivateKey:     prKey,
-		PublicKey:      pubKey,
-		PacketReceiver: make(chan SchedulerEntry),
-		ExportedFiles:  files,
-		Lock:           sync.Mutex{},
+		Socket:        sock,
+		Peers:         NewPeerRegistry(),
+		ExportedFiles: files,
+		views:         make(map[string]*filestructure.Directory),
+		stopped:       make(chan struct{}),
+		Cache: RemoteCache{
+			Nodes: make(map[[32]byte]RemoteNode),
+			Sizes: make(map[[32]byte]int64),
+		},
 	}
+	sched.keys.Store(&keyPair{private: prKey, public: pubKey})
 
 	return &sched
 }
 
+/*
+Returns the currently exported tree
+*/
+func (sched *Scheduler) Exports() *filestructure.Directory {
+	sched.ExportsLock.RLock()
+	defer sched.ExportsLock.RUnlock()
+	return sched.ExportedFiles
+}
+
+/*
+Replaces the exported tree, peers see it with the next Root exchange
+The caller closes the provider of the previous tree, see node.SetExports
+*/
+func (sched *Scheduler) SetExports(files *filestructure.Directory) {
+	sched.ExportsLock.Lock()
+	defer sched.ExportsLock.Unlock()
+	sched.ExportedFiles = files
+
+	sched.viewsLock.Lock()
+	sched.views = make(map[string]*filestructure.Directory)
+	sched.viewsLock.Unlock()
+}
+
+/*
+Replaces our keys
+Messages built from then on are signed with the new key, the ones being
+built keep the previous pair
+*/
+func (sched *Scheduler) SetKeys(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) {
+	sched.keys.Store(&keyPair{private: privateKey, public: publicKey})
+}
+
+/*
+Our public key, as sent with PublicKey
+*/
+func (sched *Scheduler) PublicKey() *ecdsa.PublicKey {
+	return sched.keys.Load().public
+}
+
+func (sched *Scheduler) privateKey() *ecdsa.PrivateKey {
+	return sched.keys.Load().private
+}
+
 func verifyDatumHash(datum DatumBody) bool {
 	hash := sha256.Sum256(datum.Value)
 	return hash == datum.Hash
 }
 
-func (sched *Scheduler) DownloadNode(node *filestructure.Node, ip string) (*filestructure.Node, error) {
-
-	var acc []byte
-	for _, child := range node.Children {
-		acc = append(acc, child.Hash[:]...)
-	}
-	b := sha256.Sum256(acc)
-	if bytes.Equal(b[:], node.Hash[:]) {
-		return nil, errors.New("merkle tree could not verify hash")
-	}
+var ErrNoResponse = errors.New("no response")
 
-	ipAddr, _ := net.ResolveUDPAddr("udp", ip)
+func (sched *Scheduler) HandleReceive(received UDPMessage, from net.Addr) {
+	sched.handleReceive(received, from, true)
+}
 
-	getDatum := UDPMessage{
-		Id:     uint32(rand.Int31()),
-		Type:   GetDatum,
-		Length: 32,
-		Body:   node.Hash[:],
-	}
+// a Hello from an unknown address is a peer that moved when mayMigrate, see migrate
+func (sched *Scheduler) handleReceive(received UDPMessage, from net.Addr, mayMigrate bool) {
 
-	for _, child := range node.Children {
-		if config.DebugSpam {
-			fmt.Println("Requesting child to insert")
-		}
+	distantPeer, _ := net.ResolveUDPAddr("udp", from.String())
 
-		getDatum.Body = child.Hash[:]
+	// the reply to a challenge only proves a key, see challenge
+	if received.Type == HelloReply && sched.replies.deliverChallenge(SchedulerEntry{From: from, Time: time.Now(), Packet: received}) {
+		return
+	}
+	if sched.parkUntilKeyProven(received, from) {
+		return
+	}
 
-		packet, err := sched.SendPacket(getDatum, ipAddr)
-		if err != nil {
-			fmt.Println("Downloading node: ", err.Error())
-			return nil, errors.New("downloading node")
+	// encrypted bodies are checked once decrypted
+	if peer, ok := sched.PeerByAddress(from); ok {
+		if err := sched.open(peer, &received); err != nil {
+			schedLogger.Warn("message rejected", sched.peerAttrs(from), messageAttrs(received), "reason", err)
+			sched.reject(received, distantPeer, err)
+			return
 		}
+	}
 
-		body := BytesToDatumBody(packet.Packet.Body)
+	if err := received.CheckBody(); err != nil {
+		schedLogger.Warn("message dropped", sched.peerAttrs(from), messageAttrs(received), "length", received.Length, "err", err)
+		return
+	}
 
-		switch body.Value[0] {
-		case 0: //chunk
-			newChunk := filestructure.Chunk{
-				Data: body.Value[1:],
-				Hash: body.Hash,
-			}
-			if child.Name != "" {
-				newChunk.Name = child.Name
+	//register user in the database, a Hello opens a new session once the previous one expired or was reset
+	verified := false
+	if received.Type == HelloReply || received.Type == Hello {
+		body := BytesToHelloBody(received.Body)
+		peer, ok := sched.PeerByAddress(from)
+		if !ok || peer.SessionState() == StateExpired || peer.SessionState() == StateUnknown {
+			if mayMigrate && sched.migrate(body.Name, received, distantPeer) {
+				return
 			}
 
-			node.Data = append(node.Data, newChunk)
-		case 1: //bigfile
-
-			newBig := filestructure.Bigfile{
-				Hash: body.Hash,
+			newPeer := &PeerInfo{
+				Name:       body.Name,
+				Extensions: body.Extensions,
+				Negotiated: sched.Extensions & body.Extensions,
+				lastSeen:   time.Now(),
 			}
 
-			if child.Name != "" {
-				newBig.Name = child.Name
+			// the Hello is checked with the key from the server before anything is registered
+			key, known := sched.cachedDirectoryKey(body.Name)
+			if !known {
+				sched.parkUntilKeyKnown(body.Name, received, from)
+				return
 			}
+			newPeer.DirectoryKey = key
 
-			for i := 1; i < len(body.Value); i += 32 {
-				newBig.Children = append(newBig.Children, filestructure.Child{
-					Hash: [32]byte(body.Value[i : i+32]),
-				})
+			if err := sched.checkSignature(newPeer, received); err != nil {
+				schedLogger.Warn("message rejected", sched.peerAttrs(from), messageAttrs(received), "name", body.Name, "reason", err)
+				sched.reject(received, distantPeer, err)
+				return
 			}
+			verified = true
 
-			node.Data = append(node.Data, newBig)
-		case 2: //directory
-
-			newDir := filestructure.Directory{
-				Name: child.Name,
-				Hash: body.Hash,
-			}
-			for i := 1; i < len(body.Value); i += 64 {
-				newDir.Children = append(newDir.Children, filestructure.Child{
-					Name: string(body.Value[i : i+32]),
-					Hash: [32]byte(body.Value[i+32 : i+64]),
-				})
-			}
-			node.Data = append(node.Data, newDir)
+			sched.Peers.bind(distantPeer, newPeer)
 		}
 	}
 
-	for i, data := range node.Data {
-		if datanode, ok := data.(filestructure.Directory); ok {
-			nodeTmp, err := sched.DownloadNode((*filestructure.Node)(&datanode), ip)
-			if err != nil {
-				fmt.Println("downloading child Directory: ", err.Error())
-				return nil, errors.New("downloading child directory")
-			}
-			node.Data[i] = (filestructure.Directory)(*nodeTmp)
-		} else if datanode, ok := data.(filestructure.Bigfile); ok {
-			nodeTmp, err := sched.DownloadNode((*filestructure.Node)(&datanode), ip)
-			if err != nil {
-				fmt.Println("downloading child bigfile: ", err.Error())
-				return nil, errors.New("downloading child bigfile")
-			}
-			node.Data[i] = (filestructure.Bigfile)(*nodeTmp)
-		}
+	//if the user is not present in the database, ignore the message as it did not complete handshake
+	peer, ok := sched.PeerByAddress(from)
+	if !ok && received.Type == ErrorReply {
+		// our Hello was refused, the pending request fails with the reason
+		schedLogger.Warn("error reply from peer", sched.peerAttrs(from), messageAttrs(received), "error", string(received.Body))
+		sched.deliver(received, from)
+		return
+	}
+	if !ok {
+		schedLogger.Debug("ignored message, handshake not completed", sched.peerAttrs(from), messageAttrs(received))
+		return
 	}
 
-	return node, nil
-}
-
-func (sched *Scheduler) HandleReceive(received UDPMessage, from net.Addr) {
+	schedLogger.Debug("received", sched.peerAttrs(from), messageAttrs(received))
 
-	//register user in the database
-	if received.Type == HelloReply || received.Type == Hello {
-		body := BytesToHelloBody(received.Body)
-		if _, ok := sched.PeerDatabase[from.String()]; !ok {
-			sched.PeerDatabase[from.String()] = &PeerInfo{
-				Name: body.Name,
-			}
+	if !verified {
+		if err := sched.checkSignature(peer, received); err != nil {
+			schedLogger.Warn("message rejected", sched.peerAttrs(from), messageAttrs(received), "reason", err)
+			sched.reject(received, distantPeer, err)
+			return
 		}
+		sched.follow(peer, received, distantPeer)
 	}
 
-	//if the user is not present in the database, ignore the message as it did not complete handshake
-	peer, ok := sched.PeerDatabase[from.String()]
-	if !ok {
-		if config.Debug {
-			fmt.Println("Ignored Message from " + from.String() + " (did not complete handshake)")
+	//messages must follow the order of the handshake
+	if state := peer.SessionState(); state == StateExpired || state < requiredState(received.Type) {
+		err := ErrHandshake
+		if state == StateExpired {
+			err = ErrSessionExpired
 		}
+		schedLogger.Debug("message out of order", sched.peerAttrs(from), messageAttrs(received), "state", state.String())
+		sched.reject(received, distantPeer, err)
 		return
 	}
-
-	if len(peer.PublicKey) > 0 && len(received.Signature) > 0 {
-		peerPuKey := crypto.ParsePublicKey(peer.PublicKey)
-		if crypto.VerifyMessage(received.MessageToBytes()[:7+received.Length], received.Signature, &peerPuKey) == false {
-			fmt.Println("\n", received.Type, " Wrong packet signature")
-			return
-		}
+	if err := sched.checkExtension(received.Type, from); err != nil {
+		schedLogger.Debug("message of an extension not negotiated", sched.peerAttrs(from), messageAttrs(received))
+		sched.reject(received, distantPeer, err)
+		return
 	}
-
-	distantPeer, _ := net.ResolveUDPAddr("udp", from.String())
+	peer.touch()
 
 	//otherwise handle the messages
 	switch received.Type {
 	case NoOp:
-		if config.Debug {
-			fmt.Println("NoOp from: " + peer.Name)
-		}
 	case Error:
-		if config.Debug {
-			fmt.Println("Error from: ", peer.Name, "\t", string(received.Body))
-		}
+		schedLogger.Warn("error from peer", sched.peerAttrs(from), messageAttrs(received), "error", string(received.Body))
+	case NatTraversal:
+		sched.handleTraversal(received, from)
+	case NatTraversalRequest:
+		sched.relayTraversal(received, distantPeer)
 	case Hello:
-		if config.Debug {
-			fmt.Println("Hello from: " + peer.Name)
-		}
-
+		peer.advance(StateHello)
 		sched.SendHelloReply(distantPeer, received.Id)
 	case PublicKey:
-		if config.Debug {
-			fmt.Println("PublicKey from: " + peer.Name)
+		if err := sched.checkPublicKey(peer, received.Body); err != nil {
+			schedLogger.Warn("public key rejected", sched.peerAttrs(from), messageAttrs(received), "reason", err)
+			sched.reject(received, distantPeer, err)
+			return
 		}
-
-		if received.Length != 0 {
-			peer.PublicKey = received.Body
-		} else {
-			peer.PublicKey = nil
+		if err := sched.checkKnownKey(peer, received.Body, distantPeer); err != nil {
+			sched.reject(received, distantPeer, err)
+			return
 		}
+		sched.setPublicKey(peer, received.Body)
+		peer.advance(StateKeyKnown)
 		sched.SendPublicKeyReply(distantPeer, received.Id)
+		sched.challengeKey(peer, distantPeer)
 	case Root:
-		if config.Debug {
-			fmt.Println("Root from: " + peer.Name)
+		if _, err := sched.exportsFor(peer); err != nil {
+			schedLogger.Info("access denied", sched.peerAttrs(from), messageAttrs(received), "fingerprint", crypto.Fingerprint(peer.PublicKey()))
+			sched.reject(received, distantPeer, err)
+			return
 		}
-
-		peer.Root = [32]byte(received.Body)
+		peer.setRoot([32]byte(received.Body))
+		peer.advance(StateRootKnown)
 		sched.SendRootReply(distantPeer, received.Id)
 	case GetDatum:
+		// the peer completed the handshake and uses the session
+		peer.advance(StateEstablished)
+		peer.transferred()
 
-		if config.Debug {
-			fmt.Println("Getdatum from: " + peer.Name)
+		// reply with the resquested node datum, if it is part of what the peer may read
+		exports, err := sched.exportsFor(peer)
+		if err != nil {
+			schedLogger.Info("access denied", sched.peerAttrs(from), messageAttrs(received), "fingerprint", crypto.Fingerprint(peer.PublicKey()))
+			sched.reject(received, distantPeer, err)
+			return
 		}
+		node := (*filestructure.Node)(exports).GetNode([32]byte(received.Body))
 
-		// reply with the resquested node datum
-		node := (*filestructure.Node)(sched.ExportedFiles).GetNode([32]byte(received.Body))
+		// the content of exported chunks is only read when requested
+		if chunk, ok := node.(filestructure.Chunk); ok {
+			data, err := chunk.Content()
+			if err != nil {
+				schedLogger.Error("reading exported chunk", "file", chunk.Name, "err", err)
+				node = nil
+			} else {
+				chunk.Data = data
+				node = chunk
+			}
+		}
 
 		if node != nil {
 			var nodeBytes []byte
@@ -260,145 +303,108 @@ func (sched *Scheduler) HandleReceive(received UDPMessage, from net.Addr) {
 			}
 
 			msg := UDPMessage{
-				Id:     received.Id,
-				Type:   Datum,
-				Length: uint16(len(nodeBytes)),
-				Body:   nodeBytes,
+				Id:         received.Id,
+				Type:       Datum,
+				Length:     uint16(len(nodeBytes)),
+				Body:       nodeBytes,
+				PrivateKey: sched.privateKey(),
 			}
 
-			err := sched.Socket.SendPacket(msg, distantPeer)
-			if err != nil {
-				fmt.Println("Respond Datum: ", err.Error())
-				return
-			}
+			sched.send(msg, distantPeer)
 		} else {
+			schedLogger.Debug("no datum for requested hash", sched.peerAttrs(from), messageAttrs(received), "hash", hex.EncodeToString(received.Body))
 			msg := UDPMessage{
-				Id:     received.Id,
-				Type:   NoDatum,
-				Length: 0,
-			}
-			err := sched.Socket.SendPacket(msg, distantPeer)
-			if err != nil {
-				fmt.Println("Respond no datum: ", err.Error())
-				return
+				Id:         received.Id,
+				Type:       NoDatum,
+				Length:     0,
+				PrivateKey: sched.privateKey(),
 			}
+			sched.send(msg, distantPeer)
 		}
 	case HelloReply:
-		if config.Debug {
-			fmt.Println("HelloReply From: " + peer.Name)
-		}
-		entry := SchedulerEntry{
-			From:   from,
-			Time:   time.Now(),
-			Packet: received,
-		}
-		sched.PacketReceiver <- entry
+		peer.advance(StateHello)
+		sched.deliver(received, from)
 	case PublicKeyReply:
-		if config.Debug {
-			fmt.Println("PublicKeyReply from: " + peer.Name)
+		if err := sched.checkPublicKey(peer, received.Body); err != nil {
+			schedLogger.Warn("public key rejected", sched.peerAttrs(from), messageAttrs(received), "reason", err)
+			sched.reject(received, distantPeer, err)
+			return
 		}
-
-		if received.Length != 0 {
-			peer.PublicKey = received.Body
-		} else {
-			peer.PublicKey = nil
+		if err := sched.checkKnownKey(peer, received.Body, distantPeer); err != nil {
+			sched.reject(received, distantPeer, err)
+			return
 		}
+		sched.setPublicKey(peer, received.Body)
+		peer.advance(StateKeyKnown)
 
-		entry := SchedulerEntry{
-			From:   from,
-			Time:   time.Now(),
-			Packet: received,
-		}
-		sched.PacketReceiver <- entry
+		sched.deliver(received, from)
 	case RootReply:
-		if config.Debug {
-			fmt.Println("RootReply from: " + peer.Name)
-			emptyHash := sha256.Sum256([]byte(""))
-			if bytes.Equal(emptyHash[:], received.Body) {
-				fmt.Println("The peer does not export any files")
-			}
-		}
-		sched.PeerDatabase[distantPeer.String()].Root = [32]byte(received.Body)
-		entry := SchedulerEntry{
-			From:   from,
-			Time:   time.Now(),
-			Packet: received,
+		if bytes.Equal(emptyRoot[:], received.Body) {
+			schedLogger.Debug("peer does not export any files", sched.peerAttrs(from))
 		}
-		sched.PacketReceiver <- entry
+		peer.setRoot([32]byte(received.Body))
+		peer.advance(StateRootKnown)
+		sched.deliver(received, from)
 	case Datum:
-		if !verifyDatumHash(BytesToDatumBody(received.Body)) {
-			if config.Debug {
-				fmt.Println("Invalid hash for datum")
-			}
-		}
-		if config.Debug {
-			body := BytesToDatumBody(received.Body)
-
-			fmt.Println("\nDatum from: " + peer.Name)
-			switch body.Value[0] {
-			case 0:
-				fmt.Println("Received Chunk")
-			case 1:
-				fmt.Println("Received BigFile")
-				fmt.Println("Number of children: " + (strconv.Itoa((len(body.Value) - 1) / 32)))
-			case 2:
-				fmt.Println("Received Directory")
-				fmt.Println("Number of files: ", (len(body.Value)-1)/64)
-				for i := 1; i < len(body.Value); i += 64 {
-					fmt.Println(string(body.Value[i : i+32]))
-				}
-				fmt.Println()
-			}
+		peer.transferred()
+		body := BytesToDatumBody(received.Body)
+		if !verifyDatumHash(body) {
+			schedLogger.Warn("invalid hash for datum", sched.peerAttrs(from), messageAttrs(received))
 		}
-		entry := SchedulerEntry{
-			From:   from,
-			Time:   time.Now(),
-			Packet: received,
+		if len(body.Value) > 0 {
+			schedLogger.Log(context.Background(), logging.LevelTrace, "datum", sched.peerAttrs(from), messageAttrs(received),
+				"hash", hex.EncodeToString(body.Hash[:]), "node", body.Value[0], "size", len(body.Value)-1)
 		}
-		sched.PacketReceiver <- entry
+		sched.deliver(received, from)
 	case NoDatum:
-		if config.Debug {
-			fmt.Println("NoDatum from: " + peer.Name)
-		}
-		entry := SchedulerEntry{
-			From:   from,
-			Time:   time.Now(),
-			Packet: received,
-		}
-		sched.PacketReceiver <- entry
+		sched.deliver(received, from)
 
 	case ErrorReply:
-		if config.Debug {
-			fmt.Println("ErrorReply from: " + peer.Name)
-			fmt.Println(string(received.Body))
-		}
+		schedLogger.Warn("error reply from peer", sched.peerAttrs(from), messageAttrs(received), "error", string(received.Body))
+		// fails the pending request instead of waiting for its timeout
+		sched.deliver(received, from)
 	default:
-		fmt.Println(received.Type, " from: ", peer.Name)
+		schedLogger.Debug("unhandled message type", sched.peerAttrs(from), messageAttrs(received))
 	}
 }
 
 func (sched *Scheduler) ReceivePending(sock *UDPSock) {
+	defer close(sched.stopped)
+
 	for {
 		received, from, err := sock.ReceivePacket()
-		if err != nil {
-			//TODO handle
-			fmt.Println("error receiving")
+		if errors.Is(err, net.ErrClosed) {
+			// socket closed on shutdown
+			return
+		} else if err != nil {
+			logger.Warn("receiving", "err", err)
+			continue
 		}
-		sched.HandleReceive(received, from)
+		sched.receive(received, from)
 	}
 }
 
+// messages are handled one at a time, by the reception loop or once parked
+func (sched *Scheduler) receive(received UDPMessage, from net.Addr) {
+	sched.receiveParked(parkedMessage{received: received, from: from})
+}
+
+func (sched *Scheduler) receiveParked(message parkedMessage) {
+	sched.receiveLock.Lock()
+	defer sched.receiveLock.Unlock()
+	sched.handleReceive(message.received, message.from, !message.unmoved)
+}
+
 /*
 	This function manages all I/O on the socket
 
 It automatically receives packets, performs a treatment then sends all pending packets
 */
 func (sched *Scheduler) Launch(sock *UDPSock) {
-	if config.DebugSpam {
-		fmt.Println("Launching scheduler")
-	}
+	schedLogger.Log(context.Background(), logging.LevelTrace, "launching scheduler")
 
 	go sched.ReceivePending(sock)
+	go sched.expirePeers()
 }
 
 /*
@@ -406,35 +412,44 @@ This function signals to the Launch function that a packet is waiting to be sent
 */
 func (sched *Scheduler) SendPacket(message UDPMessage, dest *net.UDPAddr) (SchedulerEntry, error) {
 
-	sched.Lock.Lock()
-	defer sched.Lock.Unlock()
+	err := sched.checkExtension(message.Type, dest)
+	if err == nil {
+		err = sched.seal(&message, dest)
+	}
+	if err != nil {
+		schedLogger.Warn("request not sent", sched.peerAttrs(dest), messageAttrs(message), "err", err)
+		return SchedulerEntry{}, err
+	}
 
-	timeout := 1
-	for i := 0; i < 3; i++ {
+	replies, done := sched.replies.await(message.Id, dest)
+	defer done()
+
+	timeout := config.RequestTimeout
+	for i := 0; i < config.RequestRetries; i++ {
 
 		sendTime := time.Now()
 		err := sched.Socket.SendPacket(message, dest)
-		if err == nil && config.DebugSpam {
-			fmt.Println("Message sent on socket")
+		if err != nil {
+			logger.Warn("sending failed", sched.peerAttrs(dest), messageAttrs(message), "err", err)
 		}
 
 		select {
-		case response := <-sched.PacketReceiver:
-			if response.Packet.Id != message.Id {
-				fmt.Println("wrong response ID\nExpected: " + strconv.Itoa(int(message.Id)) + "\nReceived: " + strconv.Itoa(int(response.Packet.Id)))
-			} else {
-				receivedTime := time.Now()
-				sched.PeerDatabase[dest.String()].RTT = receivedTime.Sub(sendTime).Milliseconds()
-				return response, nil
-			}
-		case <-time.After(time.Second * time.Duration(timeout)):
-			if config.Debug {
-				fmt.Println("Packet lost -> reemitting")
+		case response := <-replies:
+			if peer, ok := sched.PeerByAddress(dest); ok {
+				peer.recordRTT(time.Since(sendTime))
 			}
+			sched.proveReply(dest, response)
+			return response, nil
+		case <-time.After(timeout):
+			schedLogger.Debug("no reply, retransmitting", sched.peerAttrs(dest), messageAttrs(message), "attempt", i+1, "timeout", timeout)
 			timeout *= 2
 		}
 	}
-	return SchedulerEntry{}, errors.New("no response")
+	schedLogger.Info("request abandoned", sched.peerAttrs(dest), messageAttrs(message), "attempts", config.RequestRetries)
+	if peer, ok := sched.PeerByAddress(dest); ok {
+		peer.recordFailure()
+	}
+	return SchedulerEntry{}, ErrNoResponse
 }
 
 /*
@@ -0,0 +1,166 @@
+package udptypes
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+/*
+Progress of the handshake with a peer
+Each step requires the previous one: Hello, then PublicKey, then Root.
+The session is established once the peer served data after the handshake
+*/
+type SessionState uint8
+
+const (
+	StateUnknown SessionState = iota
+	StateHello
+	StateKeyKnown
+	StateRootKnown
+	StateEstablished
+	StateExpired
+)
+
+// a session without any message from the peer for that long must be redone
+const SessionTimeout = 3 * time.Minute
+
+var ErrHandshake = errors.New("handshake not completed")
+var ErrSessionExpired = errors.New("session expired")
+
+var stateNames = map[SessionState]string{
+	StateUnknown:     "unknown",
+	StateHello:       "hello exchanged",
+	StateKeyKnown:    "key known",
+	StateRootKnown:   "root known",
+	StateEstablished: "established",
+	StateExpired:     "expired",
+}
+
+func (state SessionState) String() string {
+	return stateNames[state]
+}
+
+/*
+State of the session, expired when the peer has been silent for too long
+*/
+func (peer *PeerInfo) SessionState() SessionState {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.sessionState()
+}
+
+// the lock of the peer is held
+func (peer *PeerInfo) sessionState() SessionState {
+	if peer.state != StateUnknown && time.Since(peer.lastSeen) > SessionTimeout {
+		return StateExpired
+	}
+	return peer.state
+}
+
+// moves the session forward, never back: a step done again keeps the state
+func (peer *PeerInfo) advance(state SessionState) {
+	peer.lock.Lock()
+	changed := peer.state < state
+	if changed {
+		peer.state = state
+		if state == StateEstablished {
+			peer.established = time.Now()
+		}
+	}
+	peer.lock.Unlock()
+
+	if changed {
+		peer.notify(PeerStateChanged)
+	}
+}
+
+// the session must be redone from the start
+func (peer *PeerInfo) reset() {
+	peer.lock.Lock()
+	changed := peer.state != StateUnknown
+	peer.state = StateUnknown
+	peer.established = time.Time{}
+	peer.lock.Unlock()
+
+	if changed {
+		peer.notify(PeerStateChanged)
+	}
+}
+
+/*
+State of the session with the peer at this address
+*/
+func (sched *Scheduler) SessionState(addr net.Addr) SessionState {
+	peer, ok := sched.PeerByAddress(addr)
+	if !ok {
+		return StateUnknown
+	}
+	return peer.SessionState()
+}
+
+/*
+Forgets the progress of every session, they are redone on the next Connect
+and the peers must send Hello again
+*/
+func (sched *Scheduler) ResetSessions() {
+	for _, peer := range sched.Peers.All() {
+		peer.reset()
+	}
+}
+
+/*
+State a peer must have reached before we accept a message of this type,
+Hello and its reply open the session so they are always accepted
+*/
+func requiredState(msgType uint8) SessionState {
+	switch msgType {
+	case Hello, HelloReply:
+		return StateUnknown
+	case PublicKey, PublicKeyReply:
+		return StateHello
+	case Root, RootReply:
+		return StateKeyKnown
+	case GetDatum, Datum, NoDatum:
+		return StateRootKnown
+	default:
+		return StateHello
+	}
+}
+
+/*
+Performs the handshake with the peer unless the session is established:
+Hello, PublicKey, Root, then fetches the root node of the peer
+An expired session is redone from the start
+*/
+func (sched *Scheduler) Connect(dest *net.UDPAddr) (*PeerInfo, error) {
+
+	if peer, ok := sched.PeerByAddress(dest); ok && peer.SessionState() == StateEstablished {
+		return peer, nil
+	}
+
+	schedLogger.Debug("connecting", sched.peerAttrs(dest), "state", sched.SessionState(dest).String())
+
+	if _, err := sched.Hello(dest); err != nil {
+		return nil, err
+	}
+	if _, err := sched.GetPublicKey(dest); err != nil {
+		return nil, err
+	}
+	root, err := sched.GetRoot(dest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = sched.FetchNode(root, dest); err != nil {
+		return nil, err
+	}
+
+	peer, ok := sched.PeerByAddress(dest)
+	if !ok {
+		return nil, ErrHandshake
+	}
+	peer.advance(StateEstablished)
+	schedLogger.Info("session established", sched.peerAttrs(dest))
+
+	return peer, nil
+}
@@ -0,0 +1,323 @@
+package udptypes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"protocoles-internet-2023/crypto"
+	"protocoles-internet-2023/rest"
+	"sync"
+	"time"
+)
+
+var ErrUnsigned = errors.New("message must be signed")
+var ErrNoKey = errors.New("no public key known for the peer")
+var ErrKeyMismatch = errors.New("public key differs from the one registered on the server")
+
+func handshakeType(msgType uint8) bool {
+	switch msgType {
+	case Hello, HelloReply, PublicKey, PublicKeyReply, Root, RootReply:
+		return true
+	}
+	return false
+}
+
+// types carrying a signature in the protocol
+func signedType(msgType uint8) bool {
+	switch msgType {
+	case Datum, NoDatum, ErrorReply:
+		return true
+	}
+	return handshakeType(msgType)
+}
+
+// time given to the server to publish a key, Hellos from the peer wait for it
+const DirectoryKeyTimeout = 3 * time.Second
+
+// answers of the server about a key, failures included, are kept that long
+const DirectoryKeyTTL = time.Minute
+
+// lookups running at once and messages waiting for each, the others are dropped
+const (
+	maxKeyLookups     = 32
+	maxParkedMessages = 4
+	maxCachedKeys     = 1024
+)
+
+/*
+Keys of the peers on the server, fetched outside of the reception loop
+A Hello from a peer whose key is not known yet is parked until the server
+answered, then handled again
+*/
+type directoryKeyCache struct {
+	lock   sync.Mutex
+	known  map[string]cachedKey
+	parked parking // by name of the peer
+}
+
+type cachedKey struct {
+	key     []byte // empty when the peer has none or the server did not answer
+	fetched time.Time
+}
+
+type parkedMessage struct {
+	received UDPMessage
+	from     net.Addr
+	unmoved  bool // the sender did not prove it is a peer that moved, see migrate
+}
+
+/*
+Messages kept while something they depend on is awaited outside of the
+reception loop, by what is awaited. They are handled again once it ended
+*/
+type parking struct {
+	lock    sync.Mutex
+	waiting map[string][]parkedMessage
+}
+
+// false when it is already awaited, or when max things are
+func (p *parking) begin(key string, max int) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.waiting == nil {
+		p.waiting = make(map[string][]parkedMessage)
+	}
+	if _, ok := p.waiting[key]; ok || len(p.waiting) >= max {
+		return false
+	}
+	p.waiting[key] = nil
+	return true
+}
+
+/*
+Keeps the message while key is awaited, false when it is not
+Beyond maxParkedMessages the message is dropped, the peer retransmits it
+*/
+func (p *parking) park(key string, message parkedMessage) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	parked, ok := p.waiting[key]
+	if !ok {
+		return false
+	}
+	if len(parked) < maxParkedMessages {
+		p.waiting[key] = append(parked, message)
+	}
+	return true
+}
+
+// the messages kept while key was awaited
+func (p *parking) end(key string) []parkedMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	parked := p.waiting[key]
+	delete(p.waiting, key)
+	return parked
+}
+
+/*
+Public key registered by the peer on the server, empty if it has none
+*/
+func (sched *Scheduler) directoryKey(ctx context.Context, name string) ([]byte, error) {
+	key, err := sched.Directory.GetPeerKey(ctx, name)
+	if errors.Is(err, rest.ErrNoKey) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return crypto.FormatPublicKey(*key), nil
+}
+
+/*
+Key of the peer on the server as last fetched, known is false when it must
+be fetched first
+Signatures ignored or no server: there is no key to wait for
+*/
+func (sched *Scheduler) cachedDirectoryKey(name string) (key []byte, known bool) {
+	if sched.SignaturePolicy == crypto.PolicyOff || sched.Directory == nil {
+		return nil, true
+	}
+
+	cache := &sched.directoryKeys
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cached, ok := cache.known[name]
+	if !ok || time.Since(cached.fetched) > DirectoryKeyTTL {
+		return nil, false
+	}
+	return cached.key, true
+}
+
+/*
+Asks the server for the key of the peer, for at most DirectoryKeyTimeout,
+and keeps the answer. A failure is kept too, as a missing key: the server is
+not asked again for every Hello
+*/
+func (sched *Scheduler) fetchDirectoryKey(name string) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), DirectoryKeyTimeout)
+	defer cancel()
+
+	key, err := sched.directoryKey(ctx, name)
+	if err != nil {
+		schedLogger.Warn("could not fetch the key of the peer from the server", "peer", name, "err", err)
+	}
+
+	cache := &sched.directoryKeys
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.known == nil {
+		cache.known = make(map[string]cachedKey)
+	}
+	if len(cache.known) >= maxCachedKeys {
+		for cachedName, cached := range cache.known {
+			if time.Since(cached.fetched) > DirectoryKeyTTL {
+				delete(cache.known, cachedName)
+			}
+		}
+	}
+	cache.known[name] = cachedKey{key: key, fetched: time.Now()}
+	return key
+}
+
+/*
+Fetches the key of a peer we are about to contact, so that its reply is
+checked without waiting for the server
+*/
+func (sched *Scheduler) prefetchDirectoryKey(name string) {
+	if _, known := sched.cachedDirectoryKey(name); !known {
+		sched.fetchDirectoryKey(name)
+	}
+}
+
+/*
+Keeps a message from a peer whose key is not known until the server
+answered, the message is then handled again
+*/
+func (sched *Scheduler) parkUntilKeyKnown(name string, received UDPMessage, from net.Addr) {
+	parked := &sched.directoryKeys.parked
+	message := parkedMessage{received: received, from: from}
+	if parked.park(name, message) {
+		return
+	}
+	if !parked.begin(name, maxKeyLookups) {
+		schedLogger.Debug("message dropped, too many waiting for the server", sched.peerAttrs(from), messageAttrs(received), "name", name)
+		return
+	}
+	parked.park(name, message)
+	schedLogger.Debug("waiting for the key of the peer from the server", sched.peerAttrs(from), messageAttrs(received), "name", name)
+
+	go func() {
+		sched.fetchDirectoryKey(name)
+		for _, message := range parked.end(name) {
+			sched.receive(message.received, message.from)
+		}
+	}()
+}
+
+/*
+Checks the signature of a received message against the policy, over the
+bytes of the datagram as they arrived
+The key registered on the server is trusted over the one sent by the peer
+Failures are ErrUnsigned, ErrNoKey or those of crypto.VerifySignature
+*/
+func (sched *Scheduler) checkSignature(peer *PeerInfo, received UDPMessage) error {
+	policy := sched.SignaturePolicy
+	if policy == crypto.PolicyOff {
+		return nil
+	}
+
+	required := (policy == crypto.PolicyRequiredForHandshake && handshakeType(received.Type)) ||
+		(policy == crypto.PolicyRequiredForAll && signedType(received.Type))
+
+	key := peer.DirectoryKey
+	if len(key) == 0 {
+		key = peer.PublicKey()
+	}
+
+	if len(received.Signature) == 0 {
+		if required {
+			return ErrUnsigned
+		}
+		return nil
+	}
+	if len(key) == 0 {
+		if required {
+			return ErrNoKey
+		}
+		return nil
+	}
+
+	return verifySignature(received, key)
+}
+
+// over the bytes of the datagram before the signature
+func verifySignature(received UDPMessage, key []byte) error {
+	return crypto.VerifySignature(received.Raw[:len(received.Raw)-len(received.Signature)], received.Signature, key)
+}
+
+/*
+Checks the key sent with PublicKey or PublicKeyReply is valid and matches
+the one on the server, an empty key means the peer does not sign
+*/
+func (sched *Scheduler) checkPublicKey(peer *PeerInfo, key []byte) error {
+	if len(key) != 0 {
+		if err := crypto.ValidatePublicKey(key); err != nil {
+			return err
+		}
+	}
+	if sched.SignaturePolicy == crypto.PolicyOff || len(peer.DirectoryKey) == 0 {
+		return nil
+	}
+	if !bytes.Equal(key, peer.DirectoryKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+/*
+Compares the key sent by the peer with the one pinned for its name, a
+changed key is refused. It is recorded, for accept-key, once the peer
+proved it holds it
+*/
+func (sched *Scheduler) checkKnownKey(peer *PeerInfo, key []byte, addr *net.UDPAddr) error {
+	if sched.KnownPeers == nil {
+		return nil
+	}
+
+	err := sched.KnownPeers.Verify(peer.Name, key)
+	if err != nil {
+		schedLogger.Warn("public key differs from the pinned one", "peer", peer.Name, "err", err)
+		sched.challengeChangedKey(peer.Name, key, addr)
+	}
+	return err
+}
+
+/*
+Refuses a message: a request is answered with an ErrorReply explaining why,
+a reply fails the request waiting for it
+*/
+func (sched *Scheduler) reject(received UDPMessage, from *net.UDPAddr, reason error) {
+
+	if received.Type < ErrorReply {
+		if received.Type != NoOp && received.Type != Error {
+			sched.SendErrorReply(from, received.Id, reason.Error())
+		}
+		return
+	}
+	if received.Type == ErrorReply {
+		return
+	}
+
+	entry := SchedulerEntry{
+		From: from,
+		Time: time.Now(),
+		Packet: UDPMessage{
+			Id:   received.Id,
+			Type: ErrorReply,
+			Body: []byte(reason.Error()),
+		},
+		Err: reason,
+	}
+	sched.deliverEntry(entry)
+}
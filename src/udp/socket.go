@@ -1,13 +1,41 @@
 package udptypes
 
 import (
+	"context"
 	"errors"
 	"net"
+	"protocoles-internet-2023/logging"
 )
 
-func NewUDPSocket() (*UDPSock, error) {
+/*
+Opens the socket on address (host:port), an empty address listens on
+every interface with a random port
+Without host the socket is dual-stack, one socket reaching both IPv4 and
+IPv6 peers; it falls back to IPv4 on hosts without IPv6
+*/
+func NewUDPSocket(address string) (*UDPSock, error) {
 
-	ret, err := net.ListenUDP("udp", &net.UDPAddr{})
+	localAddr := &net.UDPAddr{}
+	if address != "" {
+		var err error
+		localAddr, err = net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ret *net.UDPConn
+	var err error
+	if localAddr.IP == nil {
+		// a wildcard address binds [::] with IPV6_V6ONLY off
+		ret, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified, Port: localAddr.Port})
+		if err != nil {
+			logger.Warn("dual-stack socket unavailable, listening on IPv4 only", "err", err)
+			ret, err = net.ListenUDP("udp4", localAddr)
+		}
+	} else {
+		ret, err = net.ListenUDP("udp", localAddr)
+	}
 
 	sock := UDPSock{
 		Socket: ret,
@@ -21,10 +49,15 @@ func (sock *UDPSock) SendPacket(pack UDPMessage, addr *net.UDPAddr) error {
 	bytes := pack.MessageToBytes()
 
 	bytesWritten, err := sock.Socket.WriteToUDP(bytes, addr)
-	if bytesWritten != len(bytes) || err != nil {
+	if err != nil {
+		return err
+	}
+	if bytesWritten != len(bytes) {
 		return errors.New("message truncated")
 	}
 
+	logger.Log(context.Background(), logging.LevelTrace, "datagram sent", "to", addr.String(), messageAttrs(pack), "size", bytesWritten)
+
 	return nil
 }
 
@@ -37,13 +70,17 @@ func (sock *UDPSock) ReceivePacket() (UDPMessage, net.Addr, error) {
 
 	sizeReceived, from, err := sock.Socket.ReadFromUDP(received)
 	if err != nil {
-		return UDPMessage{}, nil, nil
+		return UDPMessage{}, nil, err
 	}
 	if err == nil && sizeReceived == size {
 		err = errors.New("message truncated")
 	}
 
-	msg := received[:sizeReceived].BytesToMessage()
+	msg, parseErr := received[:sizeReceived].BytesToMessage()
+	if parseErr != nil {
+		return UDPMessage{}, from, parseErr
+	}
+	logger.Log(context.Background(), logging.LevelTrace, "datagram received", "from", from.String(), messageAttrs(msg), "size", sizeReceived)
 
 	return msg, from, err
 }
@@ -3,8 +3,11 @@ package udptypes
 import (
 	"crypto/ecdsa"
 	"net"
+	"protocoles-internet-2023/crypto"
 	"protocoles-internet-2023/filestructure"
+	"protocoles-internet-2023/rest"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,6 +41,7 @@ type UDPMessage struct {
 	Body       []byte
 	Signature  []byte
 	PrivateKey *ecdsa.PrivateKey //optional, if public key is provided, then the message will be signed
+	Raw        UDPMessageBytes   // datagram as received, nil for the messages we send
 }
 
 type HelloBody struct {
@@ -59,21 +63,100 @@ type SchedulerEntry struct {
 	To     *net.UDPAddr
 	From   net.Addr
 	Packet UDPMessage
+	Err    error // reply refused by us, Packet is then an ErrorReply
+}
+
+// our keys, the private one signs what we send
+type keyPair struct {
+	private *ecdsa.PrivateKey
+	public  *ecdsa.PublicKey
 }
 
 type Scheduler struct {
-	Lock           sync.Mutex
-	Socket         UDPSock
-	PacketReceiver chan SchedulerEntry
-	PeerDatabase   map[string]*PeerInfo
-	PrivateKey     *ecdsa.PrivateKey
-	PublicKey      *ecdsa.PublicKey
-	ExportedFiles  *filestructure.Directory
+	Name            string // sent in Hello and HelloReply, config.ClientName when empty
+	Cache           RemoteCache
+	Socket          UDPSock
+	Peers           *PeerRegistry
+	ExportedFiles   *filestructure.Directory
+	ExportsLock     sync.RWMutex // exports can be replaced while requests are served
+	Directory       *rest.Client // REST server, where the keys of the peers are checked, nil for none
+	SignaturePolicy crypto.SignaturePolicy
+	KnownPeers      *crypto.KnownPeers // keys pinned on first use, nil to trust any key
+	Encryption      crypto.EncryptionPolicy
+	Extensions      int32        // advertised in Hello and HelloReply, see RegisterExtension
+	Access          *AccessRules // nil to let every peer read all the exports
+	Relay           bool         // answer NatTraversalRequest as the directory server does
+
+	views     map[string]*filestructure.Directory // exports filtered by the access rules
+	viewsLock sync.Mutex
+
+	server     *net.UDPAddr // directory server, for NAT traversal
+	serverLock sync.RWMutex
+
+	keys    atomic.Pointer[keyPair] // replaced as a whole, see SetKeys
+	replies pendingReplies          // requests waiting for their reply
+
+	directoryKeys directoryKeyCache // keys of the peers on the server
+	proofs        parking           // requests of the peers proving their key, by address
+	receiveLock   sync.Mutex        // received messages are handled one at a time
+
+	stopped chan struct{} // closed when the reception loop ends
 }
 
+// node types, first byte of a datum value
+const (
+	ChunkNode     uint8 = 0
+	BigfileNode         = 1
+	DirectoryNode       = 2
+)
+
+/*
+A node of a distant peer's tree, fetched on demand with GetDatum
+Data is only set for chunks, Children for bigfiles and directories
+*/
+type RemoteNode struct {
+	Name     string
+	Hash     [32]byte
+	Type     uint8
+	Data     []byte
+	Children []filestructure.Child
+}
+
+/*
+Nodes are content addressed so what was fetched once never changes:
+directories and bigfiles are kept to avoid walking the tree again,
+and the size of every node we traversed is remembered to skip over it
+*/
+type RemoteCache struct {
+	Lock  sync.Mutex
+	Nodes map[[32]byte]RemoteNode
+	Sizes map[[32]byte]int64
+}
+
+/*
+A peer we exchanged messages with
+Name, DirectoryKey and the extensions are set before the peer is registered
+and never change, the other fields are read and written under its lock
+through the accessors, see Snapshot
+*/
 type PeerInfo struct {
-	Name      string
-	PublicKey []byte
-	Root      [32]byte
-	RTT       int64
+	Name         string
+	DirectoryKey []byte // key registered on the server, empty if the peer has none
+	Extensions   int32  // advertised by the peer in its Hello
+	Negotiated   int32  // extensions advertised by both of us
+
+	lock        sync.Mutex
+	registry    *PeerRegistry // set once registered, for the notifications
+	address     *net.UDPAddr  // where the peer was last authenticated
+	addresses   []string      // every address the peer was seen at
+	publicKey   []byte
+	keyProven   bool              // the peer showed it holds the private key of publicKey
+	transport   *crypto.Transport // nil unless the bodies are encrypted
+	root        [32]byte
+	state       SessionState
+	lastSeen    time.Time // last message accepted from the peer
+	transfer    time.Time // last GetDatum or Datum exchanged with the peer
+	established time.Time // end of the last handshake
+	rtt         []int64   // last round-trip times in ms, oldest first
+	failures    int       // requests left without reply since the last answered one
 }